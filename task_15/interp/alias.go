@@ -0,0 +1,158 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func (sh *shell) parse(src string) (*list, error) {
+	return parseAliases(src, sh.aliases)
+}
+
+func isAliasName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n=/$`'\"\\;&|()<>")
+}
+
+func builtinAlias(sh *shell, args []string, std stdio) int {
+	if len(args) == 1 {
+		names := make([]string, 0, len(sh.aliases))
+		for name := range sh.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(std.out, "alias %s=%s\n", name, shellQuote(sh.aliases[name]))
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args[1:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			if value, ok := sh.aliases[name]; ok {
+				fmt.Fprintf(std.out, "alias %s=%s\n", name, shellQuote(value))
+			} else {
+				fmt.Fprintf(std.err, "alias: %s: not found\n", name)
+				status = 1
+			}
+			continue
+		}
+		if !isAliasName(name) {
+			fmt.Fprintf(std.err, "alias: '%s': invalid alias name\n", name)
+			status = 1
+			continue
+		}
+		sh.aliases[name] = value
+	}
+	return status
+}
+
+func builtinUnalias(sh *shell, args []string, std stdio) int {
+	if len(args) == 2 && args[1] == "-a" {
+		clear(sh.aliases)
+		return 0
+	}
+	if len(args) == 1 {
+		fmt.Fprintln(std.err, "unalias: usage: unalias [-a] name [name ...]")
+		return 2
+	}
+	status := 0
+	for _, name := range args[1:] {
+		if _, ok := sh.aliases[name]; !ok {
+			fmt.Fprintf(std.err, "unalias: %s: not found\n", name)
+			status = 1
+			continue
+		}
+		delete(sh.aliases, name)
+	}
+	return status
+}
+
+// loadRC runs ~/.maxishellrc, if it exists, in the current shell.
+func (sh *shell) loadRC(std stdio) {
+	home, ok := sh.getVar("HOME")
+	if !ok {
+		return
+	}
+	file := filepath.Join(home, ".maxishellrc")
+	src, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintln(std.err, "maxishell:", err)
+		}
+		return
+	}
+	sh.runSource(file, string(src), std)
+}
+
+// prompt returns the value of the prompt variable name, or def if it is
+// unset, with the escapes \u (user), \h (host), \w (directory, with $HOME
+// shown as ~), \W (its last element), \$ (# for root, $ otherwise), \n
+// and \\ replaced.
+func (sh *shell) prompt(name, def string) string {
+	ps, ok := sh.getVar(name)
+	if !ok {
+		return def
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(ps); i++ {
+		if ps[i] != '\\' || i+1 == len(ps) {
+			b.WriteByte(ps[i])
+			continue
+		}
+		i++
+		switch ps[i] {
+		case 'u':
+			if u, err := user.Current(); err == nil {
+				b.WriteString(u.Username)
+			}
+		case 'h':
+			host, _ := os.Hostname()
+			host, _, _ = strings.Cut(host, ".")
+			b.WriteString(host)
+		case 'w':
+			b.WriteString(sh.tildePath(sh.dir))
+		case 'W':
+			if dir := sh.tildePath(sh.dir); dir == "~" || dir == "/" {
+				b.WriteString(dir)
+			} else {
+				b.WriteString(filepath.Base(dir))
+			}
+		case '$':
+			if os.Geteuid() == 0 {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('$')
+			}
+		case 'n':
+			b.WriteByte('\n')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(ps[i])
+		}
+	}
+	return b.String()
+}
+
+// tildePath returns dir with a leading $HOME replaced by ~.
+func (sh *shell) tildePath(dir string) string {
+	home, ok := sh.getVar("HOME")
+	if !ok || home == "" || home == "/" {
+		return dir
+	}
+	if dir == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(dir, home+"/"); ok {
+		return "~/" + rest
+	}
+	return dir
+}
@@ -0,0 +1,141 @@
+package interp
+
+import (
+	"bytes"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAliases(t *testing.T) {
+	aliases := map[string]string{
+		"ll":    "ls -l",
+		"ls":    "ls -F",
+		"sudo":  "sudo ",
+		"a":     "b",
+		"b":     "a",
+		"quiet": "> /dev/null",
+		"when":  "if true; then",
+		"none":  "",
+	}
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"ll /tmp", "ls -F -l /tmp"},
+		{"ls; echo ls", "ls -F; echo ls"},
+		{"sudo ll x", "sudo ls -F -l x"},
+		{"echo ll | ll", "echo ll | ls -F -l"},
+		{"X=1 ll", "X=1 ls -F -l"},
+		{"a", "a"},
+		{"'ll'", "'ll'"},
+		{"quiet echo", "echo >/dev/null"},
+		{"when echo yes; fi", "if true; then echo yes; fi"},
+		{"none echo", "echo"},
+		{"f() { ll; }", "f() { ls -F -l; }"},
+	}
+	for _, c := range cases {
+		prog, err := parseAliases(c.src, aliases)
+		if err != nil {
+			t.Errorf("parseAliases(%q): %v", c.src, err)
+			continue
+		}
+		if got := prog.String(); got != c.want {
+			t.Errorf("parseAliases(%q) = %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	p := newParser("a; b &\n\n c | d\ncat <<E\nbody\nE\nif x\nthen y; fi\n", nil)
+	var got []string
+	for {
+		l, err := p.parseLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l == nil {
+			break
+		}
+		got = append(got, l.String())
+	}
+	want := []string{"a; b &", "c | d", "cat <<E\nbody\nE\n", "if x; then y; fi"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+
+	p = newParser("echo ok\nfi\necho never", nil)
+	if l, err := p.parseLine(); err != nil || l.String() != "echo ok" {
+		t.Fatalf("first line = %v, %v", l, err)
+	}
+	if _, err := p.parseLine(); err == nil || err.Error() != "2:1: unexpected 'fi'" {
+		t.Errorf("second line error = %v", err)
+	}
+}
+
+func TestAliasBuiltins(t *testing.T) {
+	sh := newShell()
+	var out bytes.Buffer
+	std := stdio{in: strings.NewReader(""), out: &out, err: &out}
+	src := `alias greet='echo hello' two="echo 'a b'"
+greet world; two
+alias
+alias greet nope
+unalias greet
+greet 2>/dev/null || echo gone
+alias 'bad/name=x'
+unalias -a; alias
+`
+	sh.runSource("test", src, std)
+	want := "hello world\na b\n" +
+		"alias greet='echo hello'\nalias two='echo '\\''a b'\\'''\n" +
+		"alias greet='echo hello'\nalias: nope: not found\n" +
+		"gone\nalias: 'bad/name': invalid alias name\n"
+	if out.String() != want {
+		t.Errorf("output %q\nwant   %q", out.String(), want)
+	}
+}
+
+func TestRCAndPrompt(t *testing.T) {
+	home := t.TempDir()
+	rc := "alias hi='echo hi from rc'\nGREETING=set\nPS1='\\u:\\w\\$ '\n"
+	if err := os.WriteFile(filepath.Join(home, ".maxishellrc"), []byte(rc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sh := newShell()
+	sh.setVar("HOME", home)
+	sh.dir = filepath.Join(home, "src")
+
+	var out bytes.Buffer
+	std := stdio{in: strings.NewReader(""), out: &out, err: &out}
+	sh.loadRC(std)
+	sh.runSource("test", "hi; echo $GREETING", std)
+	if out.String() != "hi from rc\nset\n" {
+		t.Errorf("output %q", out.String())
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	mark := "$"
+	if os.Geteuid() == 0 {
+		mark = "#"
+	}
+	if got, want := sh.prompt("PS1", "maxishell> "), u.Username+":~/src"+mark+" "; got != want {
+		t.Errorf("PS1 = %q, want %q", got, want)
+	}
+	sh.setVar("PS1", `[\W] \\ \q`)
+	if got := sh.prompt("PS1", ""); got != `[src] \ \q` {
+		t.Errorf("PS1 = %q", got)
+	}
+	sh.dir = "/usr/lib"
+	if got := sh.prompt("PS1", ""); got != `[lib] \ \q` {
+		t.Errorf("PS1 = %q", got)
+	}
+	if got := sh.prompt("PS2", "> "); got != "> " {
+		t.Errorf("PS2 = %q", got)
+	}
+}
@@ -0,0 +1,407 @@
+package interp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var arithOps = []string{
+	"<<=", ">>=",
+	"**", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "=", "!", "~", "&", "^", "|", "?", ":", "(", ")",
+}
+
+type arithParser struct {
+	sh    *shell
+	src   string
+	pos   int
+	skip  int
+	depth int
+}
+
+func (sh *shell) arithExpand(expr string) (string, error) {
+	parts, _, err := sh.expandQuoted(expr, true)
+	if err != nil {
+		return "", err
+	}
+	n, err := sh.evalArith(joinParts(parts), 0)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func (sh *shell) evalArith(expr string, depth int) (int64, error) {
+	if depth > 64 {
+		return 0, &expandError{fmt.Sprintf("%s: expression recursion level exceeded", expr)}
+	}
+	p := &arithParser{sh: sh, src: expr, depth: depth}
+	if strings.TrimSpace(expr) == "" {
+		return 0, nil
+	}
+	n, err := p.assign()
+	if err != nil {
+		return 0, err
+	}
+	p.space()
+	if p.pos < len(p.src) {
+		return 0, p.errorf("syntax error in expression")
+	}
+	return n, nil
+}
+
+func (p *arithParser) errorf(format string, args ...any) error {
+	return &expandError{fmt.Sprintf("%s: %s (error token is \"%s\")", strings.TrimSpace(p.src), fmt.Sprintf(format, args...), p.src[p.pos:])}
+}
+
+func (p *arithParser) space() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *arithParser) peekOp() string {
+	p.space()
+	for _, op := range arithOps {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *arithParser) accept(ops ...string) string {
+	op := p.peekOp()
+	for _, want := range ops {
+		if op == want {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *arithParser) name() string {
+	p.space()
+	start := p.pos
+	if p.pos < len(p.src) && isNameStart(p.src[p.pos]) {
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *arithParser) variable(name string) (int64, error) {
+	val, ok := p.sh.getVar(name)
+	if !ok {
+		if p.sh.options["nounset"] {
+			return 0, &expandError{fmt.Sprintf("%s: unbound variable", name)}
+		}
+		return 0, nil
+	}
+	return p.sh.evalArith(val, p.depth+1)
+}
+
+func (p *arithParser) setVariable(name string, n int64) {
+	if p.skip == 0 {
+		p.sh.setVar(name, strconv.FormatInt(n, 10))
+	}
+}
+
+func (p *arithParser) assign() (int64, error) {
+	start := p.pos
+	if name := p.name(); name != "" {
+		op := p.accept("=", "+=", "-=", "*=", "/=", "%=", "<<=", ">>=", "&=", "^=", "|=")
+		if op != "" {
+			rhs, err := p.assign()
+			if err != nil {
+				return 0, err
+			}
+			n := rhs
+			if op != "=" {
+				cur, err := p.variable(name)
+				if err != nil {
+					return 0, err
+				}
+				if n, err = p.binary(op[:len(op)-1], cur, rhs); err != nil {
+					return 0, err
+				}
+			}
+			p.setVariable(name, n)
+			return n, nil
+		}
+	}
+	p.pos = start
+	return p.ternary()
+}
+
+func (p *arithParser) ternary() (int64, error) {
+	cond, err := p.level(0)
+	if err != nil {
+		return 0, err
+	}
+	if p.accept("?") == "" {
+		return cond, nil
+	}
+	if cond == 0 {
+		p.skip++
+	}
+	a, err := p.assign()
+	if cond == 0 {
+		p.skip--
+	}
+	if err != nil {
+		return 0, err
+	}
+	if p.accept(":") == "" {
+		return 0, p.errorf("expected ':'")
+	}
+	if cond != 0 {
+		p.skip++
+	}
+	b, err := p.ternary()
+	if cond != 0 {
+		p.skip--
+	}
+	if err != nil {
+		return 0, err
+	}
+	if cond != 0 {
+		return a, nil
+	}
+	return b, nil
+}
+
+var arithLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *arithParser) level(i int) (int64, error) {
+	if i == len(arithLevels) {
+		return p.power()
+	}
+	lhs, err := p.level(i + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.accept(arithLevels[i]...)
+		if op == "" {
+			return lhs, nil
+		}
+		shortCircuit := (op == "&&" && lhs == 0) || (op == "||" && lhs != 0)
+		if shortCircuit {
+			p.skip++
+		}
+		rhs, err := p.level(i + 1)
+		if shortCircuit {
+			p.skip--
+		}
+		if err != nil {
+			return 0, err
+		}
+		if lhs, err = p.binary(op, lhs, rhs); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *arithParser) power() (int64, error) {
+	base, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.accept("**") == "" {
+		return base, nil
+	}
+	exp, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	return p.binary("**", base, exp)
+}
+
+func (p *arithParser) unary() (int64, error) {
+	switch op := p.accept("++", "--", "+", "-", "!", "~"); op {
+	case "++", "--":
+		name := p.name()
+		if name == "" {
+			return 0, p.errorf("%s requires a variable", op)
+		}
+		n, err := p.variable(name)
+		if err != nil {
+			return 0, err
+		}
+		if op == "++" {
+			n++
+		} else {
+			n--
+		}
+		p.setVariable(name, n)
+		return n, nil
+	case "":
+		return p.postfix()
+	default:
+		n, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			n = -n
+		case "!":
+			n = boolInt(n == 0)
+		case "~":
+			n = ^n
+		}
+		return n, nil
+	}
+}
+
+func (p *arithParser) postfix() (int64, error) {
+	if name := p.name(); name != "" {
+		n, err := p.variable(name)
+		if err != nil {
+			return 0, err
+		}
+		if op := p.accept("++", "--"); op != "" {
+			next := n + 1
+			if op == "--" {
+				next = n - 1
+			}
+			p.setVariable(name, next)
+		}
+		return n, nil
+	}
+	return p.primary()
+}
+
+func (p *arithParser) primary() (int64, error) {
+	if p.accept("(") != "" {
+		n, err := p.assign()
+		if err != nil {
+			return 0, err
+		}
+		if p.accept(")") == "" {
+			return 0, p.errorf("missing ')'")
+		}
+		return n, nil
+	}
+
+	p.space()
+	start := p.pos
+	for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.src[p.pos] == '#') {
+		p.pos++
+	}
+	lit := p.src[start:p.pos]
+	if lit == "" {
+		return 0, p.errorf("syntax error: operand expected")
+	}
+	n, ok := parseArithNumber(lit)
+	if !ok {
+		p.pos = start
+		return 0, p.errorf("invalid number")
+	}
+	return n, nil
+}
+
+func parseArithNumber(lit string) (int64, bool) {
+	if base, digits, ok := strings.Cut(lit, "#"); ok {
+		b, err := strconv.Atoi(base)
+		if err != nil || b < 2 || b > 36 {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(digits, b, 64)
+		return n, err == nil
+	}
+	switch {
+	case strings.HasPrefix(lit, "0x") || strings.HasPrefix(lit, "0X"):
+		n, err := strconv.ParseInt(lit[2:], 16, 64)
+		return n, err == nil
+	case len(lit) > 1 && lit[0] == '0':
+		n, err := strconv.ParseInt(lit[1:], 8, 64)
+		return n, err == nil
+	}
+	n, err := strconv.ParseInt(lit, 10, 64)
+	return n, err == nil
+}
+
+func (p *arithParser) binary(op string, a, b int64) (int64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			if p.skip > 0 {
+				return 0, nil
+			}
+			return 0, p.errorf("division by 0")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	case "**":
+		if b < 0 {
+			return 0, p.errorf("exponent less than 0")
+		}
+		n := int64(1)
+		for ; b > 0; b >>= 1 {
+			if b&1 == 1 {
+				n *= a
+			}
+			a *= a
+		}
+		return n, nil
+	case "<<":
+		return a << uint64(b&63), nil
+	case ">>":
+		return a >> uint64(b&63), nil
+	case "<":
+		return boolInt(a < b), nil
+	case "<=":
+		return boolInt(a <= b), nil
+	case ">":
+		return boolInt(a > b), nil
+	case ">=":
+		return boolInt(a >= b), nil
+	case "==":
+		return boolInt(a == b), nil
+	case "!=":
+		return boolInt(a != b), nil
+	case "&":
+		return a & b, nil
+	case "^":
+		return a ^ b, nil
+	case "|":
+		return a | b, nil
+	case "&&":
+		return boolInt(a != 0 && b != 0), nil
+	case "||":
+		return boolInt(a != 0 || b != 0), nil
+	}
+	return 0, p.errorf("unknown operator %s", op)
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package interp
+
+import "testing"
+
+func TestEvalArith(t *testing.T) {
+	sh := newShell()
+	sh.vars = map[string]variable{}
+	sh.setVar("x", "7")
+	sh.setVar("expr", "x * 2")
+
+	cases := []struct {
+		expr string
+		want int64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 ** 3 ** 2", 512},
+		{"-x + 10", 3},
+		{"x % 4 == 3", 1},
+		{"x > 3 && x < 5", 0},
+		{"!0 || 1 / 0", 1},
+		{"x ? 10 : 1 / 0", 10},
+		{"1 << 4 | 3 & 1 ^ 2", 19},
+		{"~0", -1},
+		{"0x1f + 010 + 2#101", 44},
+		{"expr + 1", 15},
+	}
+	for _, c := range cases {
+		got, err := sh.evalArith(c.expr, 0)
+		if err != nil || got != c.want {
+			t.Errorf("evalArith(%q) = %d, %v; want %d", c.expr, got, err, c.want)
+		}
+	}
+
+	steps := []struct {
+		expr string
+		want int64
+		x    string
+	}{
+		{"x++", 7, "8"},
+		{"++x", 9, "9"},
+		{"x -= 4", 5, "5"},
+		{"x <<= 2", 20, "20"},
+		{"y = x--", 20, "19"},
+	}
+	for _, s := range steps {
+		got, err := sh.evalArith(s.expr, 0)
+		x, _ := sh.getVar("x")
+		if err != nil || got != s.want || x != s.x {
+			t.Errorf("evalArith(%q) = %d, %v (x=%s); want %d (x=%s)", s.expr, got, err, x, s.want, s.x)
+		}
+	}
+
+	for _, bad := range []string{"1 / 0", "1 +", "(1", "2 ** -1", "3 4", "09"} {
+		if _, err := sh.evalArith(bad, 0); err == nil {
+			t.Errorf("evalArith(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// chdir changes the shell's directory to dir, resolved against the current
+// one, and updates PWD and OLDPWD.
+func (sh *shell) chdir(dir string) error {
+	path := sh.path(dir)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", dir)
+	}
+	sh.setVar("OLDPWD", sh.dir)
+	sh.dir = filepath.Clean(path)
+	sh.setVar("PWD", sh.dir)
+	return nil
+}
+
+func builtinCd(sh *shell, args []string, std stdio) int {
+	if len(args) > 2 {
+		fmt.Fprintln(std.err, "cd: too many arguments")
+		return 1
+	}
+	var dir string
+	var ok bool
+	switch {
+	case len(args) == 1:
+		if dir, ok = sh.getVar("HOME"); !ok {
+			fmt.Fprintln(std.err, "cd: HOME not set")
+			return 1
+		}
+	case args[1] == "-":
+		if dir, ok = sh.getVar("OLDPWD"); !ok {
+			fmt.Fprintln(std.err, "cd: OLDPWD not set")
+			return 1
+		}
+	default:
+		dir = args[1]
+	}
+	if err := sh.chdir(dir); err != nil {
+		fmt.Fprintln(std.err, "cd:", err)
+		return 1
+	}
+	if len(args) == 2 && args[1] == "-" {
+		fmt.Fprintln(std.out, sh.dir)
+	}
+	return 0
+}
+
+func builtinPushd(sh *shell, args []string, std stdio) int {
+	var dir string
+	switch len(args) {
+	case 1:
+		if len(sh.dirStack) == 0 {
+			fmt.Fprintln(std.err, "pushd: no other directory")
+			return 1
+		}
+		dir = sh.dirStack[0]
+	case 2:
+		dir = args[1]
+	default:
+		fmt.Fprintln(std.err, "pushd: too many arguments")
+		return 1
+	}
+	prev := sh.dir
+	if err := sh.chdir(dir); err != nil {
+		fmt.Fprintln(std.err, "pushd:", err)
+		return 1
+	}
+	if len(args) == 1 {
+		sh.dirStack[0] = prev
+	} else {
+		sh.dirStack = append([]string{prev}, sh.dirStack...)
+	}
+	sh.printDirs(std.out, false, false)
+	return 0
+}
+
+func builtinPopd(sh *shell, args []string, std stdio) int {
+	if len(args) > 1 {
+		fmt.Fprintln(std.err, "popd: too many arguments")
+		return 1
+	}
+	if len(sh.dirStack) == 0 {
+		fmt.Fprintln(std.err, "popd: directory stack empty")
+		return 1
+	}
+	if err := sh.chdir(sh.dirStack[0]); err != nil {
+		fmt.Fprintln(std.err, "popd:", err)
+		return 1
+	}
+	sh.dirStack = sh.dirStack[1:]
+	sh.printDirs(std.out, false, false)
+	return 0
+}
+
+func builtinDirs(sh *shell, args []string, std stdio) int {
+	long, verbose := false, false
+	for _, arg := range args[1:] {
+		switch arg {
+		case "-c":
+			sh.dirStack = nil
+			return 0
+		case "-l":
+			long = true
+		case "-v":
+			verbose = true
+		default:
+			fmt.Fprintf(std.err, "dirs: %s: invalid option\n", arg)
+			return 2
+		}
+	}
+	sh.printDirs(std.out, long, verbose)
+	return 0
+}
+
+// printDirs prints the directory stack, current directory first, with
+// $HOME shown as ~ unless long is set and one entry per line if verbose.
+func (sh *shell) printDirs(w io.Writer, long, verbose bool) {
+	dirs := append([]string{sh.dir}, sh.dirStack...)
+	for i, dir := range dirs {
+		if !long {
+			dir = sh.tildePath(dir)
+		}
+		if verbose {
+			fmt.Fprintf(w, "%2d  %s\n", i, dir)
+		} else {
+			dirs[i] = dir
+		}
+	}
+	if !verbose {
+		fmt.Fprintln(w, strings.Join(dirs, " "))
+	}
+}
+
+func builtinExit(sh *shell, args []string, std stdio) int {
+	status := sh.status
+	if len(args) > 2 {
+		fmt.Fprintln(std.err, "exit: too many arguments")
+		return 1
+	}
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(std.err, "exit: %s: numeric argument required\n", args[1])
+			n = 2
+		}
+		status = n & 0xff
+	}
+	sh.exiting = true
+	sh.status = status
+	return status
+}
+
+func builtinType(sh *shell, args []string, std stdio) int {
+	args = args[1:]
+	terse := len(args) > 0 && args[0] == "-t"
+	if terse {
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		kind, desc := sh.commandType(name)
+		switch {
+		case kind == "":
+			if !terse {
+				fmt.Fprintf(std.err, "type: %s: not found\n", name)
+			}
+			status = 1
+		case terse:
+			fmt.Fprintln(std.out, kind)
+		default:
+			fmt.Fprintf(std.out, "%s is %s\n", name, desc)
+		}
+	}
+	return status
+}
+
+// commandType reports what name runs as a command word: its kind as
+// printed by type -t and a description for type.
+func (sh *shell) commandType(name string) (kind, desc string) {
+	if value, ok := sh.aliases[name]; ok {
+		return "alias", "aliased to '" + value + "'"
+	}
+	if isReserved(name) {
+		return "keyword", "a shell keyword"
+	}
+	if _, ok := sh.funcs[name]; ok {
+		return "function", "a function"
+	}
+	if isBuiltin(name) {
+		return "builtin", "a shell builtin"
+	}
+	if path, err := sh.lookPath(name); err == nil {
+		if _, err := os.Stat(sh.path(path)); err == nil {
+			return "file", path
+		}
+	}
+	return "", ""
+}
+
+func builtinWhich(sh *shell, args []string, std stdio) int {
+	status := 0
+	for _, name := range args[1:] {
+		path, err := sh.lookPath(name)
+		if err == nil {
+			_, err = os.Stat(sh.path(path))
+		}
+		if err != nil {
+			status = 1
+			continue
+		}
+		fmt.Fprintln(std.out, path)
+	}
+	return status
+}
+
+// builtinEnv prints the environment or runs a command in a modified one:
+// env [-i] [-u name] [name=value ...] [command [arg ...]].
+func builtinEnv(sh *shell, args []string, std stdio) int {
+	env := sh.environ(nil)
+	args = args[1:]
+options:
+	for len(args) > 0 {
+		switch arg := args[0]; {
+		case arg == "-i" || arg == "-":
+			env = nil
+		case arg == "-u":
+			if len(args) < 2 {
+				fmt.Fprintln(std.err, "env: option requires an argument -- 'u'")
+				return 125
+			}
+			args = args[1:]
+			env = removeEnv(env, args[0])
+		case strings.Contains(arg, "="):
+			name, _, _ := strings.Cut(arg, "=")
+			env = append(removeEnv(env, name), arg)
+		default:
+			break options
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, kv := range env {
+			fmt.Fprintln(std.out, kv)
+		}
+		return 0
+	}
+	cmd, err := sh.startExternal(args, env, std)
+	if err != nil {
+		fmt.Fprintln(std.err, "env:", err)
+		return startErrorStatus(err)
+	}
+	return sh.wait(cmd)
+}
+
+func removeEnv(env []string, name string) []string {
+	out := env[:0:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, name+"=") {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// builtinRead reads a line from stdin and splits it on IFS into the named
+// variables, the last taking the rest of the line; with no names the
+// whole line goes to REPLY. Unless -r is given, backslash escapes the
+// next character and joins continued lines.
+func builtinRead(sh *shell, args []string, std stdio) int {
+	raw := false
+	args = args[1:]
+options:
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		switch args[0] {
+		case "-r":
+			raw = true
+		case "-p":
+			if len(args) < 2 {
+				fmt.Fprintln(std.err, "read: -p: option requires an argument")
+				return 2
+			}
+			args = args[1:]
+			fmt.Fprint(std.err, args[0])
+		case "--":
+			args = args[1:]
+			break options
+		default:
+			fmt.Fprintf(std.err, "read: %s: invalid option\n", args[0])
+			return 2
+		}
+		args = args[1:]
+	}
+	for _, name := range args {
+		if !isName(name) {
+			fmt.Fprintf(std.err, "read: '%s': not a valid identifier\n", name)
+			return 1
+		}
+	}
+
+	line, escaped, eof := readInput(std.in, raw)
+	if eof && line == "" {
+		return 1
+	}
+	if len(args) == 0 {
+		sh.setVar("REPLY", line)
+	} else {
+		ifs, ok := sh.getVar("IFS")
+		if !ok {
+			ifs = " \t\n"
+		}
+		fields := splitRead(line, escaped, ifs, len(args))
+		for i, name := range args {
+			value := ""
+			if i < len(fields) {
+				value = fields[i]
+			}
+			sh.setVar(name, value)
+		}
+	}
+	if eof {
+		return 1
+	}
+	return 0
+}
+
+// readInput reads up to a newline one byte at a time, so nothing past the
+// line is consumed from in. escaped marks the bytes that were quoted with
+// a backslash.
+func readInput(in io.Reader, raw bool) (line string, escaped []bool, eof bool) {
+	if in == nil {
+		return "", nil, true
+	}
+	var buf []byte
+	b := make([]byte, 1)
+	next := func() (byte, bool) {
+		n, err := in.Read(b)
+		for n == 0 && err == nil {
+			n, err = in.Read(b)
+		}
+		return b[0], n == 1
+	}
+	for {
+		ch, ok := next()
+		if !ok {
+			return string(buf), escaped, true
+		}
+		if ch == '\n' {
+			return string(buf), escaped, false
+		}
+		if ch == '\\' && !raw {
+			if ch, ok = next(); !ok {
+				return string(buf), escaped, true
+			}
+			if ch == '\n' {
+				continue
+			}
+			buf = append(buf, ch)
+			escaped = append(escaped, true)
+			continue
+		}
+		buf = append(buf, ch)
+		escaped = append(escaped, false)
+	}
+}
+
+// splitRead splits line into at most n fields on the characters of ifs.
+// Runs of IFS whitespace count as one separator and are trimmed from both
+// ends; escaped characters never separate.
+func splitRead(line string, escaped []bool, ifs string, n int) []string {
+	isSep := func(i int) bool {
+		return !escaped[i] && strings.IndexByte(ifs, line[i]) >= 0
+	}
+	isSpace := func(i int) bool {
+		return isSep(i) && strings.IndexByte(" \t\n", line[i]) >= 0
+	}
+
+	i, end := 0, len(line)
+	for i < end && isSpace(i) {
+		i++
+	}
+	for end > i && isSpace(end-1) {
+		end--
+	}
+	var fields []string
+	for i < end {
+		if len(fields) == n-1 {
+			fields = append(fields, line[i:end])
+			break
+		}
+		start := i
+		for i < end && !isSep(i) {
+			i++
+		}
+		fields = append(fields, line[start:i])
+		for i < end && isSpace(i) {
+			i++
+		}
+		if i < end && isSep(i) {
+			i++
+			for i < end && isSpace(i) {
+				i++
+			}
+		}
+	}
+	return fields
+}
+
+// builtinUmask shows or sets the file mode creation mask of the shell. It
+// is kept in the shell rather than the process, so that a subshell's
+// umask does not leak out of it; files the shell creates for redirects
+// and the commands it starts get it applied.
+func builtinUmask(sh *shell, args []string, std stdio) int {
+	mask := sh.umask
+	switch {
+	case len(args) == 1:
+		fmt.Fprintf(std.out, "%04o\n", mask)
+	case len(args) == 2 && args[1] == "-S":
+		perms := func(shift int) string {
+			bits := ^mask >> shift & 7
+			var s []byte
+			for i, ch := range "rwx" {
+				if bits&(4>>i) != 0 {
+					s = append(s, byte(ch))
+				}
+			}
+			return string(s)
+		}
+		fmt.Fprintf(std.out, "u=%s,g=%s,o=%s\n", perms(6), perms(3), perms(0))
+	case len(args) == 2:
+		n, err := strconv.ParseUint(args[1], 8, 32)
+		if err != nil || n > 0777 {
+			fmt.Fprintf(std.err, "umask: %s: invalid octal number\n", args[1])
+			return 1
+		}
+		sh.umask = int(n)
+	default:
+		fmt.Fprintln(std.err, "umask: usage: umask [-S] [mode]")
+		return 2
+	}
+	return 0
+}
+
+// processUmask returns the umask of the process, read from
+// /proc/self/status since the umask system call can only read it by
+// changing it. It returns 022 if the file has no Umask line.
+func processUmask() int {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 022
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "Umask:"); ok {
+			if n, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32); err == nil {
+				return int(n)
+			}
+		}
+	}
+	return 022
+}
@@ -0,0 +1,171 @@
+package interp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"exit 3; echo no", "", 3},
+		{"false; exit", "", 1},
+		{"exit 257", "", 1},
+		{"exit abc", "exit: abc: numeric argument required\n", 2},
+		{"exit 1 2; echo $?", "exit: too many arguments\n1\n", 0},
+		{"(exit 4); echo $?", "4\n", 0},
+		{"f() { exit 5; echo no; }; f; echo no", "", 5},
+		{"exit 6 | true; echo $?", "0\n", 0},
+		{"true; echo $?; false; echo $?", "0\n1\n", 0},
+	}
+	for _, c := range cases {
+		sh := newShell()
+		status, out := runScript(t, sh, c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestCdAndDirStack(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, d := range []string{a, b} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"cd a; cd ../b; cd -; pwd; echo $OLDPWD", a + "\n" + a + "\n" + b + "\n", 0},
+		{"HOME=" + b + "; cd; pwd", b + "\n", 0},
+		{"unset HOME; cd", "cd: HOME not set\n", 1},
+		{"cd nope", "cd: stat " + filepath.Join(dir, "nope") + ": no such file or directory\n", 1},
+		{"HOME=" + dir + "; pushd a; pushd ../b; dirs -v; pushd; popd; popd; popd",
+			"~/a ~\n~/b ~/a ~\n 0  ~/b\n 1  ~/a\n 2  ~\n~/a ~/b ~\n~/b ~\n~\npopd: directory stack empty\n", 1},
+		{"pushd a >/dev/null; dirs -l; dirs -c; dirs -l", a + " " + dir + "\n" + a + "\n", 0},
+	}
+	for _, c := range cases {
+		sh := newShell()
+		sh.dir = dir
+		status, out := runScript(t, sh, c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestTypeWhichEnv(t *testing.T) {
+	ls, err := exec.LookPath("ls")
+	if err != nil {
+		t.Skip("ls not found")
+	}
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"f() { :; }; alias ll='ls -l'; type cd while f ll ls",
+			"cd is a shell builtin\nwhile is a shell keyword\nf is a function\nll is aliased to 'ls -l'\nls is " + ls + "\n", 0},
+		{"type -t echo for; type -t nope-cmd", "builtin\nkeyword\n", 1},
+		{"type nope-cmd", "type: nope-cmd: not found\n", 1},
+		{"which ls nope-cmd", ls + "\n", 1},
+		{"env -i A=1 B='x y' env", "A=1\nB=x y\n", 0},
+		{"export E1=on; env -u E1 | grep -c E1=; env E2=two sh -c 'echo $E1 $E2'", "0\non two\n", 0},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{`echo "a b  c d" | { read x y z; echo "[$x][$y][$z]"; }`, "[a][b][c d]\n", 0},
+		{`echo " one " | { read x y; echo "[$x][$y]"; }`, "[one][]\n", 0},
+		{`echo "  keep  " | { read; echo "[$REPLY]"; }`, "[  keep  ]\n", 0},
+		{`printf 'l1\nl2\n' | while read l; do echo got $l; done`, "got l1\ngot l2\n", 0},
+		{`echo 'x\ y z' | { read a b; echo "[$a][$b]"; }`, "[x y][z]\n", 0},
+		{`echo 'x\ y' | { read -r a b; echo "[$a][$b]"; }`, "[x\\][y]\n", 0},
+		{`printf 'a\\\nb\n' | { read v; echo $v; }`, "ab\n", 0},
+		{"IFS=: read a b c <<EOF\n1:2:3:4\nEOF\necho \"$a $b $c\"", "1 2 3:4\n", 0},
+		{"IFS=, read a b <<EOF\n, x\nEOF\necho \"[$a][$b]\"", "[][ x]\n", 0},
+		{`printf partial | { read p; echo $? $p; }`, "1 partial\n", 0},
+		{`read v </dev/null`, "", 1},
+		{`read 1x </dev/null`, "read: '1x': not a valid identifier\n", 1},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestPrintf(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{`printf '%s-%5d|%-4s|%x|%X|%o|%.2f|%c|%%\n' a 42 b 255 255 8 3.14159 xyz`, "a-   42|b   |ff|FF|10|3.14|x|%\n", 0},
+		{`printf '%s\n' 1 2 3`, "1\n2\n3\n", 0},
+		{`printf '%s %s\n' a b c`, "a b\nc \n", 0},
+		{`printf '[%*s][%.*s]\n' 4 ab 2 abcd`, "[  ab][ab]\n", 0},
+		{`printf '%b' 'a\tb\n' '\0101\c' x`, "a\tb\nA", 0},
+		{`printf '%q\n' "it's"`, `'it'\''s'` + "\n", 0},
+		{`printf '%d %d %i\n' 0x10 010 "'A"`, "16 8 65\n", 0},
+		{`printf '\x41\101\t\\\n'`, "AA\t\\\n", 0},
+		{`printf '%d\n' abc`, "printf: abc: invalid number\n0\n", 1},
+		{`printf '%z'`, "printf: %z: invalid format character\n", 1},
+		{`printf`, "printf: usage: printf format [arguments]\n", 2},
+		{`printf 'no args %s|\n'`, "no args |\n", 0},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestUmask(t *testing.T) {
+	saved := processUmask()
+	sh := newShell()
+	sh.umask = 022
+	status, out := runScript(t, sh, "umask; umask 027; umask; umask -S; umask 9")
+	want := "0022\n0027\nu=rwx,g=rx,o=\numask: 9: invalid octal number\n"
+	if status != 1 || out != want {
+		t.Errorf("status %d, output %q; want 1, %q", status, out, want)
+	}
+
+	dir := t.TempDir()
+	sh = newShell()
+	sh.dir = dir
+	sh.umask = 022
+	status, out = runScript(t, sh, "(umask 077); umask; umask 077; echo x > f; sh -c umask")
+	if status != 0 || out != "0022\n0077\n" {
+		t.Errorf("status %d, output %q", status, out)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "f")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("redirect created %v, %v; want mode 0600", info.Mode(), err)
+	}
+	if mask := processUmask(); mask != saved {
+		t.Errorf("process umask changed from %04o to %04o", saved, mask)
+	}
+}
@@ -0,0 +1,1058 @@
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type stdio struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+	// extra holds the files redirected to descriptors 3 and up, which
+	// child processes inherit.
+	extra map[int]*os.File
+}
+
+var errCommandNotFound = errors.New("command not found")
+
+type shell struct {
+	dir         string
+	dirStack    []string
+	vars        map[string]variable
+	options     map[string]bool
+	std         stdio
+	ctx         context.Context
+	name        string
+	params      []string
+	funcs       map[string]command
+	aliases     map[string]string
+	status      int
+	substStatus int
+	lastJob     *job
+	exiting     bool
+	interactive bool
+	history     *history
+	loopDepth   int
+	condDepth   int
+	pipeStatus  []int
+	limits      map[int]syscall.Rlimit
+	umask       int
+	times       *cpuTimes
+	onCommand   func(Command)
+	callDepth   int
+	breaking    int
+	continuing  int
+	returning   bool
+	jobs        []*job
+	job         *job
+	// broken is set once a pipeline stage run inside the shell writes to
+	// a pipe with no reader; see watchPipe.
+	broken *atomic.Bool
+}
+
+type job struct {
+	id   int
+	text string
+	mu   sync.Mutex
+	pids []int
+	pgid int
+	// started is closed once the job has started a process or begun
+	// running a command inside the shell, so that startJob can return.
+	started chan struct{}
+	once    sync.Once
+	done    chan struct{}
+	status  int
+	// cancel stops the job's commands; killed is the signal kill sent
+	// the job when it did.
+	cancel context.CancelFunc
+	killed syscall.Signal
+}
+
+func newShell() *shell {
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = "/"
+	}
+	vars := environVars(os.Environ())
+	delete(vars, "IFS")
+	return &shell{
+		dir:     dir,
+		vars:    vars,
+		options: make(map[string]bool),
+		std:     stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr},
+		ctx:     context.Background(),
+		name:    "maxishell",
+		funcs:   make(map[string]command),
+		aliases: make(map[string]string),
+		limits:  make(map[int]syscall.Rlimit),
+		umask:   processUmask(),
+	}
+}
+
+func (sh *shell) clone() *shell {
+	c := &shell{
+		dir:      sh.dir,
+		dirStack: append([]string(nil), sh.dirStack...),
+		vars:     make(map[string]variable, len(sh.vars)),
+		options:  make(map[string]bool, len(sh.options)),
+		std:      sh.std,
+		ctx:      sh.ctx,
+		name:     sh.name,
+		params:   sh.params,
+		funcs:    make(map[string]command, len(sh.funcs)),
+		aliases:  make(map[string]string, len(sh.aliases)),
+		status:   sh.status,
+		lastJob:  sh.lastJob,
+		job:      sh.job,
+
+		condDepth:  sh.condDepth,
+		pipeStatus: sh.pipeStatus,
+		limits:     make(map[int]syscall.Rlimit, len(sh.limits)),
+		umask:      sh.umask,
+		times:      sh.times,
+		broken:     sh.broken,
+		onCommand:  sh.onCommand,
+	}
+	for resource, lim := range sh.limits {
+		c.limits[resource] = lim
+	}
+	for name, v := range sh.vars {
+		c.vars[name] = v
+	}
+	for name, on := range sh.options {
+		c.options[name] = on
+	}
+	for name, fn := range sh.funcs {
+		c.funcs[name] = fn
+	}
+	for name, value := range sh.aliases {
+		c.aliases[name] = value
+	}
+	return c
+}
+
+func (sh *shell) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(sh.dir, name)
+}
+
+// unwinding reports whether exit, return, break or continue is pending,
+// in which case the remaining commands of the current list are skipped.
+func (sh *shell) unwinding() bool {
+	return sh.exiting || sh.returning || sh.breaking > 0 || sh.continuing > 0 || sh.ctx.Err() != nil ||
+		(sh.broken != nil && sh.broken.Load())
+}
+
+func (sh *shell) execList(l *list, std stdio) int {
+	for _, ao := range l.items {
+		if sh.unwinding() {
+			break
+		}
+		if ao.background {
+			sh.startJob(ao, std)
+			sh.status = 0
+			continue
+		}
+		sh.status = sh.execAndOr(ao, std)
+	}
+	return sh.status
+}
+
+func (sh *shell) execAndOr(ao *andOr, std stdio) int {
+	last := len(ao.pipelines) - 1
+	if last > 0 {
+		sh.condDepth++
+	}
+	status := sh.execPipeline(ao.pipelines[0], std)
+	ran := 0
+	for i, op := range ao.ops {
+		if sh.unwinding() {
+			break
+		}
+		if (op == "&&") != (status == 0) {
+			continue
+		}
+		if i+1 == last {
+			sh.condDepth--
+		}
+		status = sh.execPipeline(ao.pipelines[i+1], std)
+		ran = i + 1
+		if i+1 == last {
+			sh.condDepth++
+		}
+	}
+	if last > 0 {
+		sh.condDepth--
+	}
+	if ran == last {
+		sh.checkErrexit(status, ao.pipelines[last].bang)
+	}
+	return status
+}
+
+// checkErrexit makes the shell exit after a failed command when set -e is
+// in effect, unless the command is part of a condition or negated with !.
+func (sh *shell) checkErrexit(status int, negated bool) {
+	if status != 0 && !negated && sh.options["errexit"] && sh.condDepth == 0 && !sh.unwinding() {
+		sh.exiting = true
+		sh.status = status
+	}
+}
+
+func (sh *shell) execPipeline(pl *pipeline, std stdio) int {
+	if pl.timed {
+		return sh.execTimed(pl, std)
+	}
+	if pl.bang {
+		sh.condDepth++
+		defer func() { sh.condDepth-- }()
+	}
+	if sh.job == nil && sh.ctx.Done() != nil {
+		// Under a context that can be cancelled, a foreground pipeline gets
+		// a process group of its own, so that cancelling kills everything
+		// it started. Otherwise commands stay in the shell's group, where
+		// they get Ctrl-C from the terminal.
+		sh.job = &job{started: make(chan struct{})}
+		defer func() { sh.job = nil }()
+	}
+	var statuses []int
+	if len(pl.cmds) == 1 {
+		statuses = []int{sh.execCommand(pl.cmds[0], std)}
+	} else {
+		statuses = sh.runFullPipeline(pl.cmds, std)
+	}
+	sh.pipeStatus = statuses
+
+	status := statuses[len(statuses)-1]
+	if sh.options["pipefail"] {
+		for _, s := range statuses {
+			if s != 0 {
+				status = s
+			}
+		}
+	}
+	if pl.bang {
+		if status == 0 {
+			return 1
+		}
+		return 0
+	}
+	return status
+}
+
+func (sh *shell) execCommand(c command, std stdio) int {
+	switch c := c.(type) {
+	case *simpleCommand:
+		return sh.execSimple(c, std)
+	case *funcDef:
+		sh.funcs[c.name] = c.body
+		return 0
+	}
+
+	std, closeFiles, err := sh.applyRedirects(c.Redirects(), std)
+	if err != nil {
+		return sh.commandError(err, std)
+	}
+	defer closeFiles()
+
+	switch c := c.(type) {
+	case *subshell:
+		return sh.clone().execList(c.body, std)
+	case *braceGroup:
+		return sh.execList(c.body, std)
+	case *ifClause:
+		return sh.execIf(c, std)
+	case *loopClause:
+		return sh.execLoop(c, std)
+	case *forClause:
+		return sh.execFor(c, std)
+	case *caseClause:
+		return sh.execCase(c, std)
+	}
+	return 0
+}
+
+func (sh *shell) execSimple(c *simpleCommand, std stdio) int {
+	sh.substStatus = 0
+	args, assigns, err := sh.expandCommand(c)
+	if err != nil {
+		return sh.commandError(err, std)
+	}
+	std, closeFiles, err := sh.applyRedirects(c.redirects, std)
+	if err != nil {
+		return sh.commandError(err, std)
+	}
+	defer closeFiles()
+
+	sh.trace(assigns, args, std)
+	if len(args) == 0 {
+		sh.jobStarted()
+		for _, kv := range assigns {
+			name, value, _ := strings.Cut(kv, "=")
+			sh.setVar(name, value)
+		}
+		return sh.substStatus
+	}
+	if sh.inProcess(args[0]) {
+		return sh.runInProcess(args, assigns, std)
+	}
+	cmd, err := sh.startExternal(args, sh.environ(assigns), std)
+	if err != nil {
+		sh.jobStarted()
+		fmt.Fprintln(std.err, "maxishell:", err)
+		status := startErrorStatus(err)
+		sh.report(args, status, nil)
+		return status
+	}
+	return sh.wait(cmd)
+}
+
+// commandError reports an error that stops a command before it runs and
+// returns status 1. After an expansion error a shell that is not
+// interactive exits, as POSIX requires.
+func (sh *shell) commandError(err error, std stdio) int {
+	fmt.Fprintln(std.err, "maxishell:", err)
+	var expErr *expandError
+	if errors.As(err, &expErr) && !sh.interactive {
+		sh.exiting = true
+		sh.status = 1
+	}
+	return 1
+}
+
+// inProcess reports whether name is a function or builtin, which run
+// inside the shell rather than as a child process.
+func (sh *shell) inProcess(name string) bool {
+	_, ok := sh.funcs[name]
+	return ok || isBuiltin(name)
+}
+
+func (sh *shell) runInProcess(args, assigns []string, std stdio) int {
+	sh.jobStarted()
+	defer sh.tempAssign(assigns)()
+	var status int
+	if fn, ok := sh.funcs[args[0]]; ok {
+		status = sh.callFunction(fn, args, std)
+	} else {
+		status = sh.runBuiltin(args, std)
+	}
+	sh.report(args, status, nil)
+	return status
+}
+
+// report passes a finished command to the interpreter's OnCommand hook.
+// ps is the command's process state if it ran as a child process.
+func (sh *shell) report(args []string, status int, ps *os.ProcessState) {
+	if sh.onCommand == nil {
+		return
+	}
+	c := Command{Args: append([]string(nil), args...), Status: status}
+	if ps != nil {
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			c.Signal = signalName(ws.Signal())
+		}
+	}
+	sh.onCommand(c)
+}
+
+// trace prints an expanded command to stderr, prefixed with $PS4, when
+// set -x is in effect.
+func (sh *shell) trace(assigns, args []string, std stdio) {
+	if !sh.options["xtrace"] {
+		return
+	}
+	ps4, ok := sh.getVar("PS4")
+	if !ok {
+		ps4 = "+ "
+	}
+	words := make([]string, 0, len(assigns)+len(args))
+	for _, kv := range assigns {
+		name, value, _ := strings.Cut(kv, "=")
+		words = append(words, name+"="+shellQuote(value))
+	}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	fmt.Fprintln(std.err, ps4+strings.Join(words, " "))
+}
+
+func (sh *shell) expandCommand(c *simpleCommand) ([]string, []string, error) {
+	assigns, err := sh.expandAssigns(c.assigns)
+	if err != nil {
+		return nil, nil, err
+	}
+	args, err := sh.expandWords(c.args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return args, assigns, nil
+}
+
+func (sh *shell) applyRedirects(redirs []*redirect, std stdio) (stdio, func(), error) {
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for _, r := range redirs {
+		var target string
+		var err error
+		if r.op != "<<" && r.op != "<<-" {
+			if target, err = sh.expandString(r.target.raw); err != nil {
+				closeFiles()
+				return std, func() {}, err
+			}
+		}
+
+		switch r.op {
+		case "<", "<>":
+			flags := os.O_RDONLY
+			if r.op == "<>" {
+				flags = os.O_RDWR | os.O_CREATE
+			}
+			var f *os.File
+			f, err = sh.openFile(target, flags)
+			if err != nil {
+				break
+			}
+			files = append(files, f)
+			if r.fd == 0 {
+				std.in = f
+			} else {
+				err = std.setWriter(r.fd, f)
+			}
+		case ">", ">|", ">>", "&>", "&>>":
+			flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if strings.HasSuffix(r.op, ">>") {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+			var f *os.File
+			f, err = sh.openFile(target, flags)
+			if err != nil {
+				break
+			}
+			files = append(files, f)
+			if r.op[0] == '&' {
+				std.out, std.err = f, f
+			} else {
+				err = std.setWriter(r.fd, f)
+			}
+		case ">&":
+			switch target {
+			case "1":
+				err = std.setWriter(r.fd, std.out)
+			case "2":
+				err = std.setWriter(r.fd, std.err)
+			default:
+				if isDigits(target) {
+					n, _ := strconv.Atoi(target)
+					if f, ok := std.extra[n]; ok && n > 2 {
+						err = std.setWriter(r.fd, f)
+					} else {
+						err = fmt.Errorf("%s: bad file descriptor", target)
+					}
+					break
+				}
+				if r.fd != 1 {
+					err = fmt.Errorf("%s: ambiguous redirect", target)
+					break
+				}
+				var f *os.File
+				f, err = sh.openFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+				if err != nil {
+					break
+				}
+				files = append(files, f)
+				std.out, std.err = f, f
+			}
+		case "<&":
+			if r.fd != 0 || target != "0" {
+				err = fmt.Errorf("%s: bad file descriptor", target)
+			}
+		case "<<", "<<-", "<<<":
+			if r.fd != 0 {
+				err = fmt.Errorf("%d: bad file descriptor", r.fd)
+				break
+			}
+			body := target + "\n"
+			if r.op != "<<<" {
+				body = r.body
+				if !r.quoted {
+					body, err = sh.expandHeredoc(body)
+				}
+			}
+			std.in = strings.NewReader(body)
+		}
+		if err != nil {
+			closeFiles()
+			return std, func() {}, err
+		}
+	}
+	return std, closeFiles, nil
+}
+
+// openFile opens a file for a redirect. A file it creates gets mode 0666
+// less the shell's umask, whatever the umask of the process.
+func (sh *shell) openFile(name string, flags int) (*os.File, error) {
+	name = sh.path(name)
+	_, err := os.Lstat(name)
+	created := flags&os.O_CREATE != 0 && errors.Is(err, os.ErrNotExist)
+	f, err := os.OpenFile(name, flags, 0666&^os.FileMode(sh.umask))
+	if err == nil && created {
+		if err = f.Chmod(0666 &^ os.FileMode(sh.umask)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, err
+}
+
+func (s *stdio) setWriter(fd int, w io.Writer) error {
+	switch fd {
+	case 1:
+		s.out = w
+	case 2:
+		s.err = w
+	default:
+		// The map may be shared with the stdio this one was copied from.
+		extra := make(map[int]*os.File, len(s.extra)+1)
+		for n, f := range s.extra {
+			extra[n] = f
+		}
+		if f, ok := unwrapFile(w).(*os.File); ok {
+			extra[fd] = f
+		} else {
+			delete(extra, fd)
+		}
+		s.extra = extra
+	}
+	return nil
+}
+
+// extraFiles returns the files for descriptors 3 and up in the form
+// exec.Cmd.ExtraFiles takes them.
+func (s *stdio) extraFiles() []*os.File {
+	var files []*os.File
+	for fd, f := range s.extra {
+		for len(files) <= fd-3 {
+			files = append(files, nil)
+		}
+		files[fd-3] = f
+	}
+	return files
+}
+
+// runFullPipeline runs a multi-stage pipeline and returns the exit status
+// of every stage.
+func (sh *shell) runFullPipeline(cmds []command, std stdio) []int {
+	n := len(cmds)
+	std, wait, err := shareOutput(std)
+	if err != nil {
+		fmt.Fprintln(std.err, "maxishell: pipe error:", err)
+		return make([]int, n)
+	}
+	defer wait()
+
+	procs := make([]*exec.Cmd, n)
+	statuses := make([]int, n)
+	var wg sync.WaitGroup
+
+	closePipes := func(files ...*os.File) {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}
+
+	in := std.in
+	var inPipe *os.File
+	for i, c := range cmds {
+		stage := stdio{in: in, out: std.out, err: std.err, extra: std.extra}
+		var outPipe, nextIn *os.File
+		if i < n-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				fmt.Fprintln(std.err, "maxishell: pipe error:", err)
+				closePipes(inPipe)
+				statuses[n-1] = 1
+				break
+			}
+			stage.out = w
+			outPipe, nextIn = w, r
+		}
+
+		sc, simple := c.(*simpleCommand)
+		if !simple {
+			wg.Add(1)
+			go func(i int, sub *shell, stage stdio, inPipe, outPipe *os.File) {
+				defer wg.Done()
+				stage = sub.watchPipe(stage)
+				statuses[i] = sub.stageStatus(sub.execCommand(c, stage))
+				closePipes(inPipe, outPipe)
+			}(i, sh.clone(), stage, inPipe, outPipe)
+			in, inPipe = nextIn, nextIn
+			continue
+		}
+
+		closeFiles := func() {}
+		args, assigns, err := sh.expandCommand(sc)
+		if err == nil {
+			stage, closeFiles, err = sh.applyRedirects(sc.redirects, stage)
+		}
+		switch {
+		case err != nil:
+			statuses[i] = sh.commandError(err, std)
+		case len(args) == 0:
+		case sh.inProcess(args[0]):
+			sh.trace(assigns, args, std)
+			wg.Add(1)
+			go func(i int, sub *shell, stage stdio, closeFiles func(), inPipe, outPipe *os.File) {
+				defer wg.Done()
+				stage = sub.watchPipe(stage)
+				statuses[i] = sub.stageStatus(sub.runInProcess(args, assigns, stage))
+				closeFiles()
+				closePipes(inPipe, outPipe)
+			}(i, sh.clone(), stage, closeFiles, inPipe, outPipe)
+			in, inPipe = nextIn, nextIn
+			continue
+		default:
+			sh.trace(assigns, args, std)
+			procs[i], err = sh.startExternal(args, sh.environ(assigns), stage)
+			if err != nil {
+				fmt.Fprintln(std.err, "maxishell:", err)
+				statuses[i] = startErrorStatus(err)
+				sh.report(args, statuses[i], nil)
+			}
+		}
+		closeFiles()
+		closePipes(inPipe, outPipe)
+		in, inPipe = nextIn, nextIn
+	}
+
+	for i, proc := range procs {
+		if proc != nil {
+			statuses[i] = sh.wait(proc)
+		}
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// pipeWriter is a pipe written to by a pipeline stage run inside the
+// shell. A write that finds no reader sets broken, which stops the stage
+// as SIGPIPE would stop a process.
+type pipeWriter struct {
+	*os.File
+	broken *atomic.Bool
+}
+
+func (w pipeWriter) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	if errors.Is(err, syscall.EPIPE) {
+		w.broken.Store(true)
+	}
+	return n, err
+}
+
+// watchPipe gives sh, a pipeline stage run inside the shell, a broken
+// pipe flag and returns std with its files set to raise it.
+func (sh *shell) watchPipe(std stdio) stdio {
+	sh.broken = new(atomic.Bool)
+	for _, w := range []*io.Writer{&std.out, &std.err} {
+		if f, ok := (*w).(*os.File); ok {
+			*w = pipeWriter{f, sh.broken}
+		}
+	}
+	return std
+}
+
+// stageStatus returns the status of a stage that ended with status, or
+// that of a process killed by SIGPIPE if it wrote to a broken pipe.
+func (sh *shell) stageStatus(status int) int {
+	if sh.broken.Load() {
+		return 128 + int(syscall.SIGPIPE)
+	}
+	return status
+}
+
+// unwrapFile returns the file behind a pipeWriter, so that a child
+// process gets the pipe itself and its own SIGPIPE.
+func unwrapFile(w io.Writer) io.Writer {
+	if pw, ok := w.(pipeWriter); ok {
+		return pw.File
+	}
+	return w
+}
+
+// shareOutput replaces stdout and stderr with files that every stage of a
+// pipeline can write to as it runs. A writer that is not a file gets a pipe
+// copied to it by a single goroutine, so the stages cannot race on it; if
+// stdout and stderr are the same writer they share the pipe. The returned
+// function closes the pipes and waits for the copies to finish.
+func shareOutput(std stdio) (stdio, func(), error) {
+	var waits []func()
+	wait := func() {
+		for _, w := range waits {
+			w()
+		}
+	}
+	share := func(w io.Writer) (io.Writer, error) {
+		if _, ok := unwrapFile(w).(*os.File); ok || w == nil {
+			return w, nil
+		}
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		done := make(chan struct{})
+		go func() {
+			io.Copy(w, r)
+			r.Close()
+			close(done)
+		}()
+		waits = append(waits, func() {
+			pw.Close()
+			<-done
+		})
+		return pw, nil
+	}
+
+	same := std.out == std.err
+	out, err := share(std.out)
+	if err != nil {
+		return std, wait, err
+	}
+	errOut := out
+	if !same {
+		if errOut, err = share(std.err); err != nil {
+			wait()
+			return std, func() {}, err
+		}
+	}
+	std.out, std.err = out, errOut
+	return std, wait, nil
+}
+
+func (sh *shell) lookPath(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	path, _ := sh.getVar("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		file := filepath.Join(sh.path(dir), name)
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", name, errCommandNotFound)
+}
+
+func (sh *shell) startExternal(args []string, env []string, std stdio) (*exec.Cmd, error) {
+	path, err := sh.lookPath(args[0])
+	if err != nil {
+		return nil, err
+	}
+	execCmd := exec.CommandContext(sh.ctx, path)
+	execCmd.Args = args
+	execCmd.Dir = sh.dir
+	execCmd.Env = env
+	execCmd.Stdin = std.in
+	execCmd.Stdout = unwrapFile(std.out)
+	execCmd.Stderr = unwrapFile(std.err)
+	execCmd.ExtraFiles = std.extraFiles()
+	if len(sh.limits) > 0 || (execHook.Load() && sh.umask != processUmask()) {
+		if err := sh.limitCommand(execCmd); err != nil {
+			return nil, err
+		}
+	}
+
+	if j := sh.job; j != nil {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.pgid != 0 && syscall.Kill(-j.pgid, 0) == syscall.ESRCH {
+			// Every process of the group has exited, so the next one
+			// leads a new group.
+			j.pgid = 0
+		}
+		execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: j.pgid}
+		// Kill the whole group, so that a child's own children do not
+		// keep Wait waiting on the output pipes.
+		execCmd.Cancel = func() error {
+			j.mu.Lock()
+			pgid := j.pgid
+			j.mu.Unlock()
+			return syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+		execCmd.WaitDelay = waitDelay
+	}
+
+	if err := execCmd.Start(); err != nil {
+		return nil, err
+	}
+
+	if sh.job != nil {
+		if sh.job.pgid == 0 {
+			sh.job.pgid = execCmd.Process.Pid
+		}
+		sh.job.pids = append(sh.job.pids, execCmd.Process.Pid)
+		sh.job.once.Do(func() { close(sh.job.started) })
+	}
+	return execCmd, nil
+}
+
+// waitDelay is how long Wait waits for a command's output to be copied
+// after it exits or is killed, in case a process outside its group still
+// holds the pipe open.
+const waitDelay = time.Second
+
+func startErrorStatus(err error) int {
+	if errors.Is(err, errCommandNotFound) || errors.Is(err, os.ErrNotExist) {
+		return 127
+	}
+	return 126
+}
+
+func waitCommand(cmd *exec.Cmd) int {
+	if cmd == nil {
+		return 0
+	}
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		return 0
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return exitErr.ExitCode()
+		}
+		return 1
+	}
+	return 0
+}
+
+func (sh *shell) startJob(ao *andOr, std stdio) {
+	j := &job{
+		id:      len(sh.jobs) + 1,
+		text:    (&list{items: []*andOr{{pipelines: ao.pipelines, ops: ao.ops}}}).String(),
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, other := range sh.jobs {
+		if other.id >= j.id {
+			j.id = other.id + 1
+		}
+	}
+	sh.jobs = append(sh.jobs, j)
+
+	ctx, cancel := context.WithCancel(sh.ctx)
+	j.cancel = cancel
+	bg := sh.clone()
+	bg.job = j
+	bg.ctx = ctx
+	std.in = strings.NewReader("")
+	go func() {
+		defer cancel()
+		status := bg.execAndOr(ao, std)
+		j.mu.Lock()
+		if j.killed != 0 {
+			status = 128 + int(j.killed)
+		}
+		j.mu.Unlock()
+		j.status = status
+		j.once.Do(func() { close(j.started) })
+		close(j.done)
+	}()
+
+	// Wait only until the job has begun its first command. If that is a
+	// process, $! is then known; a job that runs builtins first does not
+	// hold the shell up.
+	<-j.started
+	sh.lastJob = j
+	if sh.interactive {
+		if pid := j.lastPid(); pid != 0 {
+			fmt.Fprintf(std.err, "[%d] %d\n", j.id, pid)
+		} else {
+			fmt.Fprintf(std.err, "[%d]\n", j.id)
+		}
+	}
+}
+
+// jobStarted marks the job the shell runs in, if any, as started.
+func (sh *shell) jobStarted() {
+	if j := sh.job; j != nil {
+		j.once.Do(func() { close(j.started) })
+	}
+}
+
+// lastPid returns the ID of the last process the job has started so far,
+// or 0.
+func (j *job) lastPid() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.pids) == 0 {
+		return 0
+	}
+	return j.pids[len(j.pids)-1]
+}
+
+// signal sends sig to the job's process group. A signal that terminates
+// by default also stops the commands the job runs inside the shell, and
+// the job's status then reports it.
+func (j *job) signal(sig syscall.Signal) error {
+	select {
+	case <-j.done:
+		return errors.New("job has terminated")
+	default:
+	}
+	j.mu.Lock()
+	pgid := j.pgid
+	if terminates(sig) {
+		j.killed = sig
+	}
+	j.mu.Unlock()
+	if pgid != 0 {
+		if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	if terminates(sig) && j.cancel != nil {
+		j.cancel()
+	}
+	return nil
+}
+
+// terminates reports whether the default action of sig ends a process.
+func terminates(sig syscall.Signal) bool {
+	switch sig {
+	case 0, syscall.SIGCHLD, syscall.SIGCONT, syscall.SIGSTOP, syscall.SIGTSTP,
+		syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGURG, syscall.SIGWINCH:
+		return false
+	}
+	return true
+}
+
+func (sh *shell) notifyJobs(w io.Writer) {
+	running := sh.jobs[:0]
+	for _, j := range sh.jobs {
+		select {
+		case <-j.done:
+			state := "Done"
+			if j.status != 0 {
+				state = fmt.Sprintf("Exit %d", j.status)
+			}
+			fmt.Fprintf(w, "[%d]  %-24s %s\n", j.id, state, j.text)
+		default:
+			running = append(running, j)
+		}
+	}
+	sh.jobs = running
+}
+
+var builtins = []string{
+	"cd", "pwd", "echo", "kill", "ps", "exit", "export", "unset", "set",
+	"test", "[", "source", ".", "break", "continue", "return", "shift", "history",
+	"alias", "unalias", "type", "which", "env", "read", "printf", ":", "true", "false",
+	"pushd", "popd", "dirs", "umask", "ulimit", "timeout", "wait",
+}
+
+func isBuiltin(cmd string) bool {
+	for _, name := range builtins {
+		if name == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func (sh *shell) runBuiltin(args []string, std stdio) int {
+	switch args[0] {
+	case "cd":
+		return builtinCd(sh, args, std)
+	case "pwd":
+		fmt.Fprintln(std.out, sh.dir)
+		return 0
+	case "echo":
+		fmt.Fprintln(std.out, strings.Join(args[1:], " "))
+		return 0
+	case "kill":
+		return builtinKill(sh, args, std)
+	case "ps":
+		return builtinPs(sh, args, std)
+	case "exit":
+		return builtinExit(sh, args, std)
+	case "export":
+		return builtinExport(sh, args, std)
+	case "unset":
+		return builtinUnset(sh, args, std)
+	case "set":
+		return builtinSet(sh, args, std)
+	case "test", "[":
+		return builtinTest(sh, args, std)
+	case "source", ".":
+		return builtinSource(sh, args, std)
+	case "break", "continue":
+		return builtinBreak(sh, args, std)
+	case "return":
+		return builtinReturn(sh, args, std)
+	case "shift":
+		return builtinShift(sh, args, std)
+	case "history":
+		return builtinHistory(sh, args, std)
+	case "alias":
+		return builtinAlias(sh, args, std)
+	case "unalias":
+		return builtinUnalias(sh, args, std)
+	case "type":
+		return builtinType(sh, args, std)
+	case "which":
+		return builtinWhich(sh, args, std)
+	case "env":
+		return builtinEnv(sh, args, std)
+	case "read":
+		return builtinRead(sh, args, std)
+	case "printf":
+		return builtinPrintf(sh, args, std)
+	case ":", "true":
+		return 0
+	case "false":
+		return 1
+	case "pushd":
+		return builtinPushd(sh, args, std)
+	case "popd":
+		return builtinPopd(sh, args, std)
+	case "dirs":
+		return builtinDirs(sh, args, std)
+	case "umask":
+		return builtinUmask(sh, args, std)
+	case "ulimit":
+		return builtinUlimit(sh, args, std)
+	case "timeout":
+		return builtinTimeout(sh, args, std)
+	case "wait":
+		return builtinWait(sh, args, std)
+	}
+	return 0
+}
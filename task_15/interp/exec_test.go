@@ -0,0 +1,354 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runScript(t *testing.T, sh *shell, src string) (int, string) {
+	t.Helper()
+	var out bytes.Buffer
+	status := runScriptTo(t, sh, src, &out)
+	return status, out.String()
+}
+
+// runScriptTo runs src with stdout and stderr going to w. Background jobs
+// may still be writing to w when it returns.
+func runScriptTo(t *testing.T, sh *shell, src string, w io.Writer) int {
+	t.Helper()
+	prog, err := parse(src)
+	if err != nil {
+		t.Fatalf("parse(%q): %v", src, err)
+	}
+	return sh.execList(prog, stdio{in: strings.NewReader(""), out: w, err: w})
+}
+
+func TestRunBuiltinEcho(t *testing.T) {
+	sh := newShell()
+	var outBuf bytes.Buffer
+
+	exit := sh.runBuiltin([]string{"echo", "hello", "world"}, stdio{out: &outBuf, err: os.Stderr})
+
+	if exit != 0 {
+		t.Errorf("runBuiltin echo exit code = %d, want 0", exit)
+	}
+	if !strings.Contains(outBuf.String(), "hello world") {
+		t.Errorf("runBuiltin echo output = %q, want to contain 'hello world'", outBuf.String())
+	}
+}
+
+func TestRunBuiltinCd(t *testing.T) {
+	oldDir, _ := os.Getwd()
+
+	sh := newShell()
+	sh.dir = os.TempDir()
+
+	exit := sh.runBuiltin([]string{"cd", oldDir}, stdio{out: os.Stdout, err: os.Stderr})
+	if exit != 0 {
+		t.Fatal("cd command failed")
+	}
+
+	realCwd, _ := filepath.EvalSymlinks(sh.dir)
+	realOldDir, _ := filepath.EvalSymlinks(oldDir)
+
+	if realCwd != realOldDir {
+		t.Errorf("expected cwd %q, got %q", realOldDir, realCwd)
+	}
+}
+
+func TestRunExternal(t *testing.T) {
+	path, err := exec.LookPath("echo")
+	if err != nil {
+		t.Skip("echo command not found")
+	}
+	sh := newShell()
+	execCmd, err := sh.startExternal([]string{path, "testexternal"}, sh.environ(nil), stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr})
+	if err != nil {
+		t.Fatalf("startExternal error: %v", err)
+	}
+	exitCode := waitCommand(execCmd)
+	t.Logf("exit code: %d", exitCode)
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+}
+
+func TestRunFullPipeline(t *testing.T) {
+	prog, err := parse("echo hello | grep he")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh := newShell()
+	statuses := sh.runFullPipeline(prog.items[0].pipelines[0].cmds, stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr})
+	if !slices.Equal(statuses, []int{0, 0}) {
+		t.Errorf("runFullPipeline statuses = %v; want [0 0]", statuses)
+	}
+}
+
+func TestPipelineStatus(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"true | false | true; echo $? ${PIPESTATUS[@]} ${#PIPESTATUS[@]}", "0 0 1 0 3\n", 0},
+		{"false | true; echo $PIPESTATUS ${PIPESTATUS[1]} x${PIPESTATUS[5]}", "1 0 x\n", 0},
+		{"false; echo ${PIPESTATUS[*]}", "1\n", 0},
+		{"set -o pipefail; false | true; echo $?", "1\n", 0},
+		{"set -o pipefail; sh -c 'exit 3' | false | true", "", 1},
+		{"set -o pipefail; false | sh -c 'exit 3' | true", "", 3},
+		{"set -o pipefail; ! false | true; echo $?", "0\n", 0},
+		{"{ echo out; false; } | cat; echo ${PIPESTATUS[@]}", "out\n1 0\n", 0},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestPipelineStderrPassesThrough(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sh := newShell()
+	prog, err := parse("echo a | sh -c 'echo middle >&2; cat' | cat; sh -c 'echo single >&2'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.execList(prog, stdio{in: strings.NewReader(""), out: &out, err: &errOut})
+	if out.String() != "a\n" || errOut.String() != "middle\nsingle\n" {
+		t.Errorf("stdout %q, stderr %q", out.String(), errOut.String())
+	}
+}
+
+func TestBuiltinsInPipelines(t *testing.T) {
+	dir := t.TempDir()
+	sh := newShell()
+	sh.dir = dir
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"echo hello | wc -c | tr -d ' '", "6\n"},
+		{"echo one two | tr ' ' '\\n' | wc -l | tr -d ' '", "2\n"},
+		{"up() { tr a-z A-Z; }; echo shout | up", "SHOUT\n"},
+		{"f() { echo $1; echo err >&2; }; f x 2>/dev/null | cat", "x\n"},
+		{"pwd > out.txt; cat out.txt", dir + "\n"},
+		{"echo a > f.txt; echo b >> f.txt; cat < f.txt | wc -l | tr -d ' '", "2\n"},
+		{"cd / | true; x=1 | true; pwd; echo x=$x", dir + "\nx=\n"},
+		{"export V=piped | true; echo ${V-unset}", "unset\n"},
+		{"echo piped | { cat; pwd; } | wc -l | tr -d ' '", "2\n"},
+		{"while true; do echo y; done | head -1; echo ${PIPESTATUS[@]}", "y\n141 0\n"},
+		{"f() { while :; do echo z; done; }; f | head -1", "z\n"},
+	}
+	// An in-process stage that misses SIGPIPE would run forever.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sh.ctx = ctx
+	for _, c := range cases {
+		if status, out := runScript(t, sh, c.src); status != 0 || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %q", c.src, status, out, c.want)
+		}
+	}
+}
+
+func TestErrexitAndXtrace(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"set -e; false || true; ! true; if false; then :; fi; while false; do :; done; echo ok", "ok\n", 0},
+		{"set -e; echo a; false; echo b", "a\n", 1},
+		{"set -e; true && false; echo b", "", 1},
+		{"set -e; false && true; echo b", "b\n", 0},
+		{"set -e; (false); echo b", "", 1},
+		{"set -e; f() { false; echo in; }; f && echo cond; f; echo b", "in\ncond\n", 1},
+		{"set -o pipefail -e; false | true; echo b", "", 1},
+		{"set -x; x='a b' echo \"hi there\" | cat; y=1; set +x; echo done", "+ x='a b' echo 'hi there'\n+ cat\nhi there\n+ y=1\n+ set +x\ndone\n", 0},
+		{"PS4='>> '; set -x; echo hi", ">> echo hi\nhi\n", 0},
+		{"set -ex; echo $-", "+ echo ex\nex\n", 0},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestRunBuiltinKillInvalid(t *testing.T) {
+	sh := newShell()
+	exit := sh.runBuiltin([]string{"kill", "abc"}, stdio{out: os.Stdout, err: os.Stderr})
+	if exit == 0 {
+		t.Error("kill with invalid pid should fail")
+	}
+}
+
+func TestRunBuiltinPs(t *testing.T) {
+	sh := newShell()
+	exit := sh.runBuiltin([]string{"ps"}, stdio{out: os.Stdout, err: os.Stderr})
+	if exit != 0 {
+		t.Error("ps command failed")
+	}
+}
+
+func TestRedirectsAppendAndDup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	sh := newShell()
+	sh.dir = t.TempDir()
+
+	script := `sh -c 'echo out; echo err 1>&2' > out.txt 2>&1
+echo appended >> out.txt
+cat <<< piped | tr a-z A-Z >> out.txt
+cat <<EOF >> out.txt
+heredoc
+EOF`
+	if status, out := runScript(t, sh, script); status != 0 {
+		t.Fatalf("script exit code = %d, output %q", status, out)
+	}
+
+	data, err := os.ReadFile(filepath.Join(sh.dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "out\nerr\nappended\nPIPED\nheredoc\n" {
+		t.Errorf("file contents = %q", data)
+	}
+}
+
+func TestRedirectExtraFd(t *testing.T) {
+	sh := newShell()
+	sh.dir = t.TempDir()
+	status, out := runScript(t, sh, "echo x 3>three.txt; echo $?; sh -c 'echo child >&3' 3>>three.txt; { echo group >&3; } 3>>three.txt | cat; echo bad >&4")
+	if status != 1 || out != "x\n0\nmaxishell: 4: bad file descriptor\n" {
+		t.Errorf("status %d, output %q", status, out)
+	}
+	data, err := os.ReadFile(filepath.Join(sh.dir, "three.txt"))
+	if err != nil || string(data) != "child\ngroup\n" {
+		t.Errorf("three.txt = %q, %v", data, err)
+	}
+}
+
+func TestExecLists(t *testing.T) {
+	sh := newShell()
+	sh.dir = t.TempDir()
+
+	cases := []struct {
+		src    string
+		status int
+		out    string
+	}{
+		{"echo a; echo b", 0, "a\nb\n"},
+		{"false && echo no || echo yes", 0, "yes\n"},
+		{"true || echo no; ! true", 1, ""},
+		{"(cd /; pwd); pwd", 0, "/\n" + sh.dir + "\n"},
+		{"{ echo x; echo y; } | wc -l | tr -d ' '", 0, "2\n"},
+		{"(echo sub; exit) ; echo after", 0, "sub\nafter\n"},
+		{"no-such-command-xyz", 127, "maxishell: no-such-command-xyz: command not found\n"},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, sh, c.src)
+		if status != c.status || out != c.out {
+			t.Errorf("%q: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.out)
+		}
+	}
+	if sh.exiting {
+		t.Error("exit inside a subshell terminated the parent shell")
+	}
+}
+
+func TestBackgroundJob(t *testing.T) {
+	sh := newShell()
+	var out bytes.Buffer
+	status := runScriptTo(t, sh, "sleep 0.05 &", &out)
+	if status != 0 || len(sh.jobs) != 1 || len(sh.jobs[0].pids) != 1 {
+		t.Fatalf("expected one running job, got status %d jobs %+v", status, sh.jobs)
+	}
+	<-sh.jobs[0].done
+
+	sh.notifyJobs(&out)
+	if !strings.Contains(out.String(), "Done") || len(sh.jobs) != 0 {
+		t.Errorf("notifyJobs output %q, remaining jobs %d", out.String(), len(sh.jobs))
+	}
+}
+
+func TestWait(t *testing.T) {
+	cases := []struct {
+		src    string
+		status int
+		out    string
+	}{
+		{"{ sleep 0.05; echo bg; } & wait; echo $?", 0, "bg\n0\n"},
+		{"sh -c 'exit 3' & wait $!; echo $?", 0, "3\n"},
+		{"(exit 4) & sleep 0.05 >/dev/null 2>&1 & wait %1; echo $?; wait", 0, "4\n"},
+		{"wait %1", 127, "wait: %1: no such job\n"},
+		{"wait 1", 127, "wait: pid 1 is not a child of this shell\n"},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.out {
+			t.Errorf("%q: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.out)
+		}
+	}
+}
+
+func TestBuiltinBackgroundJob(t *testing.T) {
+	sh := newShell()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sh.ctx = ctx
+	status, out := runScript(t, sh, "while true; do true; done & echo started [$!]")
+	if status != 0 || out != "started []\n" {
+		t.Fatalf("status %d, output %q", status, out)
+	}
+	cancel()
+	select {
+	case <-sh.jobs[0].done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not stop when the context was cancelled")
+	}
+
+	sh = newShell()
+	status, out = runScript(t, sh, "{ while :; do :; done; /bin/true; } & echo started [$!]; kill %1; wait %1; echo $?")
+	if status != 0 || out != "started []\n143\n" {
+		t.Errorf("status %d, output %q", status, out)
+	}
+}
+
+func TestVariablesAndExport(t *testing.T) {
+	sh := newShell()
+	sh.unsetVar("MAXI_X")
+
+	cases := []struct {
+		src    string
+		status int
+		out    string
+	}{
+		{"MAXI_X=1; echo $MAXI_X", 0, "1\n"},
+		{"sh -c 'echo [$MAXI_X]'", 0, "[]\n"},
+		{"MAXI_X=2 sh -c 'echo [$MAXI_X]'; echo $MAXI_X", 0, "[2]\n1\n"},
+		{"export MAXI_X; sh -c 'echo [$MAXI_X]'", 0, "[1]\n"},
+		{"unset MAXI_X; echo ${MAXI_X:-gone}", 0, "gone\n"},
+		{"false; echo $?; true; echo $?", 0, "1\n0\n"},
+		{"(set -u; echo $MAXI_NOPE)", 1, "maxishell: MAXI_NOPE: unbound variable\n"},
+		{"set +u; export 1BAD", 1, "export: '1BAD': not a valid identifier\n"},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, sh, c.src)
+		if status != c.status || out != c.out {
+			t.Errorf("%q: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.out)
+		}
+	}
+}
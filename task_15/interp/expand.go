@@ -0,0 +1,617 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// expandPart is a piece of a word after expansion. Quoted parts are
+// protected from field splitting; split parts come from unquoted
+// expansions and are subject to it. at marks parts produced by "$@", and
+// brk separates its elements into distinct fields.
+type expandPart struct {
+	text   string
+	quoted bool
+	split  bool
+	at     bool
+	brk    bool
+}
+
+type expandError struct {
+	msg string
+}
+
+func (e *expandError) Error() string {
+	return e.msg
+}
+
+func (sh *shell) expandWords(words []word) ([]string, error) {
+	var args []string
+	for _, w := range words {
+		for _, raw := range braceExpand(w.raw) {
+			fields, err := sh.expandFields(raw)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, fields...)
+		}
+	}
+	return args, nil
+}
+
+func (sh *shell) expandFields(raw string) ([]string, error) {
+	parts, err := sh.expandParts(raw)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for _, f := range sh.splitFields(parts) {
+		fields = append(fields, sh.globField(f)...)
+	}
+	return fields, nil
+}
+
+// expandString expands raw without field splitting, as is done for
+// assignments and redirection targets.
+func (sh *shell) expandString(raw string) (string, error) {
+	parts, err := sh.expandParts(raw)
+	if err != nil {
+		return "", err
+	}
+	return joinParts(parts), nil
+}
+
+func (sh *shell) expandAssigns(words []word) ([]string, error) {
+	assigns := make([]string, 0, len(words))
+	for _, w := range words {
+		name, value, _ := strings.Cut(w.raw, "=")
+		value, err := sh.expandString(value)
+		if err != nil {
+			return nil, err
+		}
+		assigns = append(assigns, name+"="+value)
+	}
+	return assigns, nil
+}
+
+func joinParts(parts []expandPart) string {
+	var buf strings.Builder
+	for _, p := range parts {
+		buf.WriteString(p.text)
+	}
+	return buf.String()
+}
+
+func (sh *shell) expandParts(raw string) ([]expandPart, error) {
+	var parts []expandPart
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, expandPart{text: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	i := 0
+	if strings.HasPrefix(raw, "~") {
+		if home, n, ok := sh.expandTilde(raw); ok {
+			parts = append(parts, expandPart{text: home, quoted: true})
+			i = n
+		}
+	}
+	for ; i < len(raw); i++ {
+		switch ch := raw[i]; ch {
+		case '\\':
+			if i+1 < len(raw) {
+				i++
+				if raw[i] != '\n' {
+					flush()
+					parts = append(parts, expandPart{text: raw[i : i+1], quoted: true})
+				}
+			}
+		case '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end < 0 {
+				end = len(raw) - i - 1
+			}
+			flush()
+			parts = append(parts, expandPart{text: raw[i+1 : i+1+end], quoted: true})
+			i += end + 1
+		case '"':
+			flush()
+			inner, n, err := sh.expandQuoted(raw[i+1:], false)
+			if err != nil {
+				return nil, err
+			}
+			if !hasAt(inner) {
+				parts = append(parts, expandPart{quoted: true})
+			}
+			parts = append(parts, inner...)
+			i += n
+		case '$':
+			val, n, err := sh.expandDollar(raw[i:], false)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				lit.WriteByte(ch)
+				continue
+			}
+			flush()
+			parts = append(parts, val...)
+			i += n - 1
+		case '`':
+			val, n, err := sh.expandBackquote(raw[i:])
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			parts = append(parts, expandPart{text: val, split: true})
+			i += n - 1
+		default:
+			lit.WriteByte(ch)
+		}
+	}
+	flush()
+	return parts, nil
+}
+
+// expandQuoted expands the contents of a double-quoted string and returns
+// the parts and the number of bytes consumed, including the closing quote.
+// In heredoc mode the whole of s is expanded and double quotes are literal.
+func (sh *shell) expandQuoted(s string, heredoc bool) ([]expandPart, int, error) {
+	var parts []expandPart
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, expandPart{text: lit.String(), quoted: true})
+			lit.Reset()
+		}
+	}
+
+	i := 0
+	for ; i < len(s); i++ {
+		ch := s[i]
+		if ch == '"' && !heredoc {
+			i++
+			break
+		}
+		switch ch {
+		case '\\':
+			escapes := "$`\\\n"
+			if !heredoc {
+				escapes += `"`
+			}
+			if i+1 < len(s) && strings.IndexByte(escapes, s[i+1]) >= 0 {
+				i++
+				if s[i] != '\n' {
+					lit.WriteByte(s[i])
+				}
+			} else {
+				lit.WriteByte(ch)
+			}
+		case '$':
+			val, n, err := sh.expandDollar(s[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			if n == 0 {
+				lit.WriteByte(ch)
+				continue
+			}
+			flush()
+			parts = append(parts, val...)
+			i += n - 1
+		case '`':
+			val, n, err := sh.expandBackquote(s[i:])
+			if err != nil {
+				return nil, 0, err
+			}
+			lit.WriteString(val)
+			i += n - 1
+		default:
+			lit.WriteByte(ch)
+		}
+	}
+	flush()
+	return parts, i, nil
+}
+
+// expandBackquote runs the `command` at the start of s and returns its
+// output and the number of bytes the substitution occupied.
+func (sh *shell) expandBackquote(s string) (string, int, error) {
+	l := &lexer{src: s}
+	l.scanBackquote()
+	if l.err != nil {
+		return "", 0, l.err
+	}
+	var src strings.Builder
+	body := s[1 : l.pos-1]
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' && i+1 < len(body) && strings.IndexByte("$`\\", body[i+1]) >= 0 {
+			i++
+		}
+		src.WriteByte(body[i])
+	}
+	val, err := sh.commandSubst(src.String())
+	return val, l.pos, err
+}
+
+// commandSubst runs src in a subshell and returns its standard output
+// with trailing newlines removed.
+func (sh *shell) commandSubst(src string) (string, error) {
+	prog, err := sh.parse(src)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	sh.substStatus = sh.clone().execList(prog, stdio{in: sh.std.in, out: &out, err: sh.std.err})
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+func (sh *shell) expandHeredoc(body string) (string, error) {
+	parts, _, err := sh.expandQuoted(body, true)
+	if err != nil {
+		return "", err
+	}
+	return joinParts(parts), nil
+}
+
+// expandDollar expands the parameter reference at the start of s and
+// returns the resulting parts and the number of bytes it occupied. A zero
+// length means the '$' is literal.
+func (sh *shell) expandDollar(s string, quoted bool) ([]expandPart, int, error) {
+	result := func(val string) []expandPart {
+		return []expandPart{{text: val, quoted: quoted, split: !quoted}}
+	}
+	if len(s) < 2 {
+		return nil, 0, nil
+	}
+
+	switch ch := s[1]; {
+	case ch == '(':
+		l := &lexer{src: s}
+		l.scanDollar()
+		if l.err != nil {
+			return nil, 0, l.err
+		}
+		var val string
+		var err error
+		if strings.HasPrefix(s, "$((") {
+			val, err = sh.arithExpand(s[3 : l.pos-2])
+		} else {
+			val, err = sh.commandSubst(s[2 : l.pos-1])
+		}
+		return result(val), l.pos, err
+	case ch == '{':
+		end := matchBrace(s, 1)
+		if end < 0 {
+			return nil, 0, &expandError{fmt.Sprintf("%s: bad substitution", s)}
+		}
+		parts, err := sh.expandBraced(s[2:end], quoted)
+		return parts, end + 1, err
+	case isNameStart(ch):
+		n := 2
+		for n < len(s) && isNameChar(s[n]) {
+			n++
+		}
+		val, err := sh.paramValue(s[1:n])
+		return result(val), n, err
+	case ch == '@' && quoted:
+		return sh.quotedAt(), 2, nil
+	case strings.IndexByte("?$!#@*-0123456789", ch) >= 0:
+		val, err := sh.paramValue(s[1:2])
+		return result(val), 2, err
+	}
+	return nil, 0, nil
+}
+
+func matchBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		}
+	}
+	return -1
+}
+
+func (sh *shell) expandBraced(expr string, quoted bool) ([]expandPart, error) {
+	result := func(val string) []expandPart {
+		return []expandPart{{text: val, quoted: quoted, split: !quoted}}
+	}
+
+	if val, ok := sh.pipeStatusParam(expr); ok {
+		return result(val), nil
+	}
+
+	if len(expr) > 1 && expr[0] == '#' {
+		name := expr[1:]
+		if !isParamName(name) {
+			return nil, &expandError{fmt.Sprintf("${%s}: bad substitution", expr)}
+		}
+		val, err := sh.paramValue(name)
+		if err != nil {
+			return nil, err
+		}
+		return result(strconv.Itoa(utf8.RuneCountInString(val))), nil
+	}
+
+	n := 0
+	if n < len(expr) && strings.IndexByte("?$!#@*-", expr[0]) >= 0 {
+		n = 1
+	} else {
+		for n < len(expr) && isNameChar(expr[n]) {
+			n++
+		}
+	}
+	name, rest := expr[:n], expr[n:]
+	if !isParamName(name) {
+		return nil, &expandError{fmt.Sprintf("${%s}: bad substitution", expr)}
+	}
+	if rest == "" {
+		if name == "@" && quoted {
+			return sh.quotedAt(), nil
+		}
+		val, err := sh.paramValue(name)
+		return result(val), err
+	}
+
+	colon := strings.HasPrefix(rest, ":")
+	if colon {
+		rest = rest[1:]
+	}
+	if rest == "" || strings.IndexByte("-=+?", rest[0]) < 0 {
+		return nil, &expandError{fmt.Sprintf("${%s}: bad substitution", expr)}
+	}
+	op, arg := rest[0], rest[1:]
+
+	val, set := sh.lookupParam(name)
+	useDefault := !set || (colon && val == "")
+
+	switch op {
+	case '-':
+		if useDefault {
+			return sh.expandOperand(arg, quoted)
+		}
+	case '=':
+		if useDefault {
+			if !isName(name) {
+				return nil, &expandError{fmt.Sprintf("$%s: cannot assign in this way", name)}
+			}
+			val, err := sh.expandString(arg)
+			if err != nil {
+				return nil, err
+			}
+			sh.setVar(name, val)
+			return result(val), nil
+		}
+	case '+':
+		if useDefault {
+			return nil, nil
+		}
+		return sh.expandOperand(arg, quoted)
+	case '?':
+		if useDefault {
+			msg := "parameter null or not set"
+			if arg != "" {
+				var err error
+				if msg, err = sh.expandString(arg); err != nil {
+					return nil, err
+				}
+			}
+			return nil, &expandError{fmt.Sprintf("%s: %s", name, msg)}
+		}
+	}
+	return result(val), nil
+}
+
+// expandOperand expands the word inside ${name-word} and friends. Inside
+// double quotes the result is quoted; otherwise literal text in it is
+// subject to field splitting like any other expansion result.
+func (sh *shell) expandOperand(raw string, quoted bool) ([]expandPart, error) {
+	parts, err := sh.expandParts(raw)
+	if err != nil {
+		return nil, err
+	}
+	for i := range parts {
+		if quoted {
+			parts[i].quoted, parts[i].split = true, false
+		} else if !parts[i].quoted {
+			parts[i].split = true
+		}
+	}
+	return parts, nil
+}
+
+// quotedAt expands "$@" to one field per positional parameter, or to no
+// field at all when there are none.
+func (sh *shell) quotedAt() []expandPart {
+	if len(sh.params) == 0 {
+		return []expandPart{{at: true}}
+	}
+	var parts []expandPart
+	for i, p := range sh.params {
+		if i > 0 {
+			parts = append(parts, expandPart{text: " ", brk: true})
+		}
+		parts = append(parts, expandPart{text: p, quoted: true, at: true})
+	}
+	return parts
+}
+
+func hasAt(parts []expandPart) bool {
+	for _, p := range parts {
+		if p.at {
+			return true
+		}
+	}
+	return false
+}
+
+func isParamName(name string) bool {
+	return isName(name) || isDigits(name) || (len(name) == 1 && strings.IndexByte("?$!#@*-", name[0]) >= 0)
+}
+
+func (sh *shell) lookupParam(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(sh.status), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		if sh.lastJob == nil {
+			return "", false
+		}
+		pid := sh.lastJob.lastPid()
+		if pid == 0 {
+			return "", false
+		}
+		return strconv.Itoa(pid), true
+	case "-":
+		return sh.optionFlags(), true
+	case "0":
+		return sh.name, true
+	case "PIPESTATUS":
+		return sh.pipeStatusParam("PIPESTATUS[0]")
+	case "#":
+		return strconv.Itoa(len(sh.params)), true
+	case "@":
+		return strings.Join(sh.params, " "), true
+	case "*":
+		sep := " "
+		if ifs, ok := sh.getVar("IFS"); ok {
+			sep = ifs[:min(1, len(ifs))]
+		}
+		return strings.Join(sh.params, sep), true
+	}
+	if isDigits(name) {
+		n, _ := strconv.Atoi(name)
+		if n < 1 || n > len(sh.params) {
+			return "", false
+		}
+		return sh.params[n-1], true
+	}
+	return sh.getVar(name)
+}
+
+// pipeStatusParam expands ${PIPESTATUS[n]}, ${PIPESTATUS[@]} and
+// ${#PIPESTATUS[@]}. PIPESTATUS is the only array the shell has.
+func (sh *shell) pipeStatusParam(expr string) (string, bool) {
+	count := strings.HasPrefix(expr, "#")
+	index, ok := strings.CutPrefix(strings.TrimPrefix(expr, "#"), "PIPESTATUS[")
+	if !ok || !strings.HasSuffix(index, "]") {
+		return "", false
+	}
+	index = strings.TrimSuffix(index, "]")
+
+	if index == "@" || index == "*" {
+		if count {
+			return strconv.Itoa(len(sh.pipeStatus)), true
+		}
+		vals := make([]string, len(sh.pipeStatus))
+		for i, s := range sh.pipeStatus {
+			vals[i] = strconv.Itoa(s)
+		}
+		return strings.Join(vals, " "), true
+	}
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 {
+		return "", false
+	}
+	if n >= len(sh.pipeStatus) {
+		return "", true
+	}
+	val := strconv.Itoa(sh.pipeStatus[n])
+	if count {
+		return strconv.Itoa(len(val)), true
+	}
+	return val, true
+}
+
+func (sh *shell) paramValue(name string) (string, error) {
+	val, ok := sh.lookupParam(name)
+	if !ok && sh.options["nounset"] && name != "@" && name != "*" {
+		return "", &expandError{fmt.Sprintf("%s: unbound variable", name)}
+	}
+	return val, nil
+}
+
+// splitFields performs IFS field splitting on the expanded parts of a word.
+func (sh *shell) splitFields(parts []expandPart) [][]expandPart {
+	ifs, ok := sh.getVar("IFS")
+	if !ok {
+		ifs = " \t\n"
+	}
+	isSpace := func(c byte) bool {
+		return (c == ' ' || c == '\t' || c == '\n') && strings.IndexByte(ifs, c) >= 0
+	}
+
+	var fields [][]expandPart
+	var cur []expandPart
+	have := false
+	for _, p := range parts {
+		if p.brk {
+			fields = append(fields, cur)
+			cur, have = nil, false
+			continue
+		}
+		if !p.split || ifs == "" {
+			cur = append(cur, p)
+			have = have || p.quoted || p.text != ""
+			continue
+		}
+		t := p.text
+		for i := 0; i < len(t); {
+			if strings.IndexByte(ifs, t[i]) < 0 {
+				j := i
+				for j < len(t) && strings.IndexByte(ifs, t[j]) < 0 {
+					j++
+				}
+				cur = append(cur, expandPart{text: t[i:j], split: true})
+				have = true
+				i = j
+				continue
+			}
+			j := i
+			for j < len(t) && isSpace(t[j]) {
+				j++
+			}
+			hard := false
+			if j < len(t) && strings.IndexByte(ifs, t[j]) >= 0 && !isSpace(t[j]) {
+				hard = true
+				j++
+				for j < len(t) && isSpace(t[j]) {
+					j++
+				}
+			}
+			if have || hard {
+				fields = append(fields, cur)
+				cur, have = nil, false
+			}
+			i = j
+		}
+	}
+	if have {
+		fields = append(fields, cur)
+	}
+	return fields
+}
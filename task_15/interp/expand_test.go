@@ -0,0 +1,139 @@
+package interp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExpandFields(t *testing.T) {
+	sh := newShell()
+	sh.vars = map[string]variable{}
+	sh.setVar("A", "hello world")
+	sh.setVar("E", "")
+	sh.setVar("P", "x:y::z")
+	sh.status = 3
+
+	cases := []struct {
+		raw  string
+		want []string
+	}{
+		{`$A`, []string{"hello", "world"}},
+		{`"$A"`, []string{"hello world"}},
+		{`'$A'`, []string{"$A"}},
+		{`\$A`, []string{"$A"}},
+		{`pre${A}post`, []string{"prehello", "worldpost"}},
+		{`$E`, nil},
+		{`"$E"`, []string{""}},
+		{`''`, []string{""}},
+		{`${#A}`, []string{"11"}},
+		{`${E:-def}`, []string{"def"}},
+		{`${E-def}`, nil},
+		{`"${E-def}"`, []string{""}},
+		{`${U:-a b}`, []string{"a", "b"}},
+		{`"${U:-a b}"`, []string{"a b"}},
+		{`${U:-"a b"}`, []string{"a b"}},
+		{`${A:+set}`, []string{"set"}},
+		{`${U:+set}`, nil},
+		{`$?`, []string{"3"}},
+		{`"a\"b\$c\d"`, []string{`a"b$c\d`}},
+		{`$`, []string{"$"}},
+		{`"$"`, []string{"$"}},
+	}
+	for _, c := range cases {
+		got, err := sh.expandFields(c.raw)
+		if err != nil {
+			t.Errorf("expandFields(%s) error: %v", c.raw, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Errorf("expandFields(%s) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+
+	if got, _ := sh.expandFields("$$"); len(got) != 1 || got[0] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("$$ = %q", got)
+	}
+
+	sh.setVar("IFS", ":")
+	got, _ := sh.expandFields("$P")
+	if strings.Join(got, "|") != "x|y||z" {
+		t.Errorf("IFS=: split = %q", got)
+	}
+}
+
+func TestExpandAssignDefaultAndErrors(t *testing.T) {
+	sh := newShell()
+	sh.vars = map[string]variable{}
+
+	got, err := sh.expandString(`${N:=value}`)
+	if err != nil || got != "value" {
+		t.Fatalf("${N:=value} = %q, %v", got, err)
+	}
+	if v, _ := sh.getVar("N"); v != "value" {
+		t.Errorf("N = %q after :=", v)
+	}
+
+	if _, err := sh.expandString(`${M:?is required}`); err == nil || err.Error() != "M: is required" {
+		t.Errorf("${M:?} error = %v", err)
+	}
+	if _, err := sh.expandString(`${A B}`); err == nil {
+		t.Error("expected bad substitution error")
+	}
+
+	status, out := runScript(t, sh, "echo ${UNSET?oops}; echo after")
+	if status != 1 || out != "maxishell: UNSET: oops\n" || !sh.exiting {
+		t.Errorf("non-interactive: status %d, output %q, exiting %v", status, out, sh.exiting)
+	}
+	sh = newShell()
+	sh.interactive = true
+	status, out = runScript(t, sh, "echo ${UNSET?oops}; echo after")
+	if status != 0 || out != "maxishell: UNSET: oops\nafter\n" || sh.exiting {
+		t.Errorf("interactive: status %d, output %q, exiting %v", status, out, sh.exiting)
+	}
+}
+
+func TestExpandHeredoc(t *testing.T) {
+	sh := newShell()
+	sh.setVar("X", "val")
+	got, err := sh.expandHeredoc("a \"$X\" \\$X ${X}\n")
+	if err != nil || got != "a \"val\" $X val\n" {
+		t.Errorf("expandHeredoc = %q, %v", got, err)
+	}
+}
+
+func TestCommandSubstitution(t *testing.T) {
+	sh := newShell()
+	sh.setVar("N", "3")
+
+	cases := []struct {
+		raw  string
+		want []string
+	}{
+		{`$(echo a b)`, []string{"a", "b"}},
+		{`"$(echo a b)"`, []string{"a b"}},
+		{`x$(printf 'one\n\n\n')y`, []string{"xoney"}},
+		{"`echo back`", []string{"back"}},
+		{"\"`echo \\\\$N`\"", []string{"$N"}},
+		{`$(echo $(echo nested) | tr a-z A-Z)`, []string{"NESTED"}},
+		{`$(echo ")")`, []string{")"}},
+		{`$((N * (2 + 1)))`, []string{"9"}},
+		{`"$(( $(echo 4) + N ))"`, []string{"7"}},
+	}
+	for _, c := range cases {
+		got, err := sh.expandFields(c.raw)
+		if err != nil {
+			t.Errorf("expandFields(%s) error: %v", c.raw, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Errorf("expandFields(%s) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+
+	status, out := runScript(t, sh, "x=$(echo out; false); echo $? $x; echo $((N / 0))")
+	if status != 1 || !strings.HasPrefix(out, "1 out\nmaxishell: N / 0: division by 0") {
+		t.Errorf("status %d, output %q", status, out)
+	}
+}
@@ -0,0 +1,238 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const maxCallDepth = 1000
+
+func (sh *shell) execIf(c *ifClause, std stdio) int {
+	for i, cond := range c.conds {
+		sh.condDepth++
+		status := sh.execList(cond, std)
+		sh.condDepth--
+		if sh.unwinding() {
+			return status
+		}
+		if status == 0 {
+			return sh.execList(c.bodies[i], std)
+		}
+	}
+	if c.elseBody != nil {
+		return sh.execList(c.elseBody, std)
+	}
+	return 0
+}
+
+// loopDone consumes one level of a pending break or continue and reports
+// whether the innermost loop must stop.
+func (sh *shell) loopDone() bool {
+	if sh.breaking > 0 {
+		sh.breaking--
+		return true
+	}
+	if sh.continuing > 0 {
+		sh.continuing--
+		return sh.continuing > 0
+	}
+	return sh.exiting || sh.returning || sh.ctx.Err() != nil || (sh.broken != nil && sh.broken.Load())
+}
+
+func (sh *shell) execLoop(c *loopClause, std stdio) int {
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+
+	status := 0
+	for {
+		sh.condDepth++
+		cond := sh.execList(c.cond, std)
+		sh.condDepth--
+		if sh.loopDone() || (cond == 0) == c.until {
+			break
+		}
+		status = sh.execList(c.body, std)
+		if sh.loopDone() {
+			break
+		}
+	}
+	return status
+}
+
+func (sh *shell) execFor(c *forClause, std stdio) int {
+	words := sh.params
+	if c.hasIn {
+		var err error
+		if words, err = sh.expandWords(c.words); err != nil {
+			return sh.commandError(err, std)
+		}
+	}
+
+	sh.loopDepth++
+	defer func() { sh.loopDepth-- }()
+
+	status := 0
+	for _, w := range words {
+		sh.setVar(c.name, w)
+		status = sh.execList(c.body, std)
+		if sh.loopDone() {
+			break
+		}
+	}
+	return status
+}
+
+func (sh *shell) execCase(c *caseClause, std stdio) int {
+	subject, err := sh.expandString(c.word.raw)
+	if err != nil {
+		return sh.commandError(err, std)
+	}
+	for _, item := range c.items {
+		for _, w := range item.patterns {
+			pattern, err := sh.expandPattern(w.raw)
+			if err != nil {
+				return sh.commandError(err, std)
+			}
+			if matchPattern(pattern, subject) {
+				return sh.execList(item.body, std)
+			}
+		}
+	}
+	return 0
+}
+
+func (sh *shell) callFunction(body command, args []string, std stdio) int {
+	if sh.callDepth >= maxCallDepth {
+		fmt.Fprintf(std.err, "maxishell: %s: maximum function nesting level exceeded (%d)\n", args[0], maxCallDepth)
+		return 1
+	}
+	savedParams, savedLoops := sh.params, sh.loopDepth
+	sh.params, sh.loopDepth = args[1:], 0
+	sh.callDepth++
+	defer func() {
+		sh.params, sh.loopDepth = savedParams, savedLoops
+		sh.callDepth--
+		sh.returning = false
+	}()
+	return sh.execCommand(body, std)
+}
+
+// runSource runs src in the current shell. name identifies the source in
+// syntax error messages.
+func (sh *shell) runSource(name, src string, std stdio) int {
+	status, err := sh.run(src, std)
+	if err != nil {
+		fmt.Fprintf(std.err, "maxishell: %s: %v\n", name, err)
+	}
+	return status
+}
+
+// run parses and runs src a line at a time, so aliases defined on one line
+// apply to the next. A syntax error stops it with status 2.
+func (sh *shell) run(src string, std stdio) (int, error) {
+	p := newParser(src, sh.aliases)
+	for !sh.exiting && !sh.returning && sh.ctx.Err() == nil {
+		l, err := p.parseLine()
+		if err != nil {
+			return 2, fmt.Errorf("syntax error: %w", err)
+		}
+		if l == nil {
+			break
+		}
+		sh.execList(l, std)
+	}
+	return sh.status, nil
+}
+
+func builtinSource(sh *shell, args []string, std stdio) int {
+	if len(args) < 2 {
+		fmt.Fprintf(std.err, "%s: filename argument required\n", args[0])
+		return 2
+	}
+	src, err := os.ReadFile(sh.path(args[1]))
+	if err != nil {
+		fmt.Fprintf(std.err, "%s: %v\n", args[0], err)
+		return 1
+	}
+
+	saved := sh.params
+	if len(args) > 2 {
+		sh.params = args[2:]
+	}
+	sh.callDepth++
+	defer func() {
+		if len(args) > 2 {
+			sh.params = saved
+		}
+		sh.callDepth--
+		sh.returning = false
+	}()
+	return sh.runSource(args[1], string(src), std)
+}
+
+// loopCount parses the optional count argument of break, continue and
+// shift.
+func loopCount(args []string, std stdio) (int, bool) {
+	if len(args) < 2 {
+		return 1, true
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		fmt.Fprintf(std.err, "%s: %s: loop count out of range\n", args[0], args[1])
+		return 0, false
+	}
+	return n, true
+}
+
+func builtinBreak(sh *shell, args []string, std stdio) int {
+	n, ok := loopCount(args, std)
+	if !ok {
+		return 1
+	}
+	if sh.loopDepth == 0 {
+		fmt.Fprintf(std.err, "%s: only meaningful in a 'for', 'while', or 'until' loop\n", args[0])
+		return 0
+	}
+	n = min(n, sh.loopDepth)
+	if args[0] == "break" {
+		sh.breaking = n
+	} else {
+		sh.continuing = n
+	}
+	return 0
+}
+
+func builtinReturn(sh *shell, args []string, std stdio) int {
+	if sh.callDepth == 0 {
+		fmt.Fprintln(std.err, "return: can only 'return' from a function or sourced script")
+		return 1
+	}
+	status := sh.status
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(std.err, "return: %s: numeric argument required\n", args[1])
+			n = 2
+		}
+		status = n & 0xff
+	}
+	sh.returning = true
+	return status
+}
+
+func builtinShift(sh *shell, args []string, std stdio) int {
+	n := 1
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+			fmt.Fprintf(std.err, "shift: %s: numeric argument required\n", args[1])
+			return 1
+		}
+	}
+	if n > len(sh.params) {
+		return 1
+	}
+	sh.params = sh.params[n:]
+	return 0
+}
@@ -0,0 +1,110 @@
+package interp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestControlFlow(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"if false; then echo a; elif true; then echo b; else echo c; fi", "b\n", 0},
+		{"if false; then echo a; fi", "", 0},
+		{"i=0; while [ $i -lt 3 ]; do echo $i; i=$((i+1)); done", "0\n1\n2\n", 0},
+		{"i=0; while :; do i=$((i+1)); [ $i = 2 ] && break; done; echo $i", "2\n", 0},
+		{"i=3; until [ $i = 0 ]; do i=$((i-1)); done; echo $i", "0\n", 0},
+		{"for x in a b c; do [ $x = b ] && continue; echo $x; done", "a\nc\n", 0},
+		{"for x in 1 2; do for y in a b; do [ $y = b ] && continue 2; [ $x = 2 ] && break 2; echo $x$y; done; done", "1a\n", 0},
+		{"for f in {x,y}.go; do echo $f; done", "x.go\ny.go\n", 0},
+		{"case hello.go in *.txt) echo txt;; *.go | *.c) echo src;; esac", "src\n", 0},
+		{`case 'a*' in "a*") echo quoted;; a*) echo glob;; esac`, "quoted\n", 0},
+		{"case abc in a?[b-d]) echo yes;; esac", "yes\n", 0},
+		{"case x in y) echo no;; esac", "", 0},
+		{"break", "break: only meaningful in a 'for', 'while', or 'until' loop\n", 0},
+		{"return", "return: can only 'return' from a function or sourced script\n", 1},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	sh := newShell()
+	src := `
+count() { echo "$#:$1:$2"; }
+count a "b c"
+count
+show() { for a; do echo "<$a>"; done; }
+show "$@" x
+set -- "p 1" p2
+show "$@"
+show $*
+echo "$*" $#
+shift; echo $1 $#
+early() { echo before; return 4; echo after; }
+early; echo $?
+fact() { if [ $1 -le 1 ]; then echo 1; else echo $(( $1 * $(fact $(($1 - 1))) )); fi; }
+fact 5
+`
+	want := "2:a:b c\n0::\n<x>\n<p 1>\n<p2>\n<p>\n<1>\n<p2>\np 1 p2 2\np2 1\nbefore\n4\n120\n"
+	if status, out := runScript(t, sh, src); status != 0 || out != want {
+		t.Errorf("status %d, output %q, want %q", status, out, want)
+	}
+
+	status, out := runScript(t, sh, "loop() { loop; }; loop")
+	if status != 1 || !strings.Contains(out, "maximum function nesting level exceeded") {
+		t.Errorf("recursion: status %d, output %q", status, out)
+	}
+}
+
+func TestTestBuiltin(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sh := newShell()
+	sh.dir = dir
+
+	cases := []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"test"}, 1},
+		{[]string{"test", ""}, 1},
+		{[]string{"test", "x"}, 0},
+		{[]string{"test", "-n"}, 0},
+		{[]string{"test", "-z", ""}, 0},
+		{[]string{"test", "-n", "="}, 0},
+		{[]string{"test", "a", "=", "a"}, 0},
+		{[]string{"test", "a", "!=", "a"}, 1},
+		{[]string{"test", "-n", "=", ""}, 1},
+		{[]string{"test", "10", "-gt", "9"}, 0},
+		{[]string{"test", "10", "-lt", "x"}, 2},
+		{[]string{"test", "!", "a", "=", "b"}, 0},
+		{[]string{"test", "a", "-a", "", "-o", "b"}, 0},
+		{[]string{"test", "(", "a", "-o", "", ")", "-a", ""}, 1},
+		{[]string{"test", "-f", "file"}, 0},
+		{[]string{"test", "-d", "file"}, 1},
+		{[]string{"test", "-d", "."}, 0},
+		{[]string{"test", "-s", "file"}, 0},
+		{[]string{"test", "-e", "missing"}, 1},
+		{[]string{"[", "a", "=", "a", "]"}, 0},
+		{[]string{"[", "a", "=", "a"}, 2},
+		{[]string{"test", "a", "b"}, 2},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if status := sh.runBuiltin(c.args, stdio{out: &out, err: &out}); status != c.status {
+			t.Errorf("%q: status %d, want %d (%s)", c.args, status, c.status, out.String())
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package interp
+
+import (
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// skipQuoted returns the index just past the quoted string, escape or
+// substitution starting at raw[i], or i+1 for an ordinary byte.
+func skipQuoted(raw string, i int) int {
+	l := &lexer{src: raw, pos: i}
+	switch raw[i] {
+	case '\\':
+		return min(i+2, len(raw))
+	case '\'':
+		l.scanSingle()
+	case '"':
+		l.scanDouble()
+	case '`':
+		l.scanBackquote()
+	case '$':
+		l.scanDollar()
+	default:
+		return i + 1
+	}
+	return l.pos
+}
+
+// braceExpand expands the first unquoted {a,b,...} in raw and recurses on
+// the results, so a{b,c}d{e,f} yields abde abdf acde acdf. Braces without
+// a top-level comma are left alone.
+func braceExpand(raw string) []string {
+	for i := 0; i < len(raw); {
+		if raw[i] != '{' {
+			i = skipQuoted(raw, i)
+			continue
+		}
+
+		depth, end := 0, -1
+		var commas []int
+		for j := i; j < len(raw) && end < 0; {
+			switch raw[j] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = j
+				}
+			case ',':
+				if depth == 1 {
+					commas = append(commas, j)
+				}
+			default:
+				j = skipQuoted(raw, j)
+				continue
+			}
+			j++
+		}
+		if end < 0 || len(commas) == 0 {
+			i++
+			continue
+		}
+
+		var out []string
+		start := i + 1
+		for _, sep := range append(commas, end) {
+			out = append(out, braceExpand(raw[:i]+raw[start:sep]+raw[end+1:])...)
+			start = sep + 1
+		}
+		return out
+	}
+	return []string{raw}
+}
+
+// expandTilde expands a leading ~ or ~user in raw, returning the home
+// directory and the length of the prefix it replaces. The prefix runs up
+// to the first slash and must not contain any quoting.
+func (sh *shell) expandTilde(raw string) (string, int, bool) {
+	end := strings.IndexByte(raw, '/')
+	if end < 0 {
+		end = len(raw)
+	}
+	name := raw[1:end]
+	if strings.ContainsAny(name, "\\'\"$`") {
+		return "", 0, false
+	}
+
+	switch name {
+	case "":
+		if home, ok := sh.getVar("HOME"); ok {
+			return home, end, true
+		}
+		if u, err := user.Current(); err == nil {
+			return u.HomeDir, end, true
+		}
+		return "", 0, false
+	case "+":
+		return sh.dir, end, true
+	case "-":
+		old, ok := sh.getVar("OLDPWD")
+		return old, end, ok
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", 0, false
+	}
+	return u.HomeDir, end, true
+}
+
+// globPattern builds a pattern from the parts of a field, escaping quoted
+// text so only unquoted *, ? and [...] act as wildcards. It also reports
+// whether any wildcard is present.
+func globPattern(parts []expandPart) (string, bool) {
+	var pattern strings.Builder
+	meta := false
+	for _, p := range parts {
+		if p.quoted {
+			for i := 0; i < len(p.text); i++ {
+				if strings.IndexByte("*?[]\\", p.text[i]) >= 0 {
+					pattern.WriteByte('\\')
+				}
+				pattern.WriteByte(p.text[i])
+			}
+			continue
+		}
+		meta = meta || strings.ContainsAny(p.text, "*?[")
+		pattern.WriteString(p.text)
+	}
+	return pattern.String(), meta
+}
+
+// expandPattern expands a case pattern without field splitting or
+// pathname expansion.
+func (sh *shell) expandPattern(raw string) (string, error) {
+	parts, err := sh.expandParts(raw)
+	if err != nil {
+		return "", err
+	}
+	pattern, _ := globPattern(parts)
+	return pattern, nil
+}
+
+// globField performs pathname expansion on a field. A pattern that matches
+// nothing is left as it is.
+func (sh *shell) globField(field []expandPart) []string {
+	literal := joinParts(field)
+	if sh.options["noglob"] {
+		return []string{literal}
+	}
+	pattern, meta := globPattern(field)
+	if !meta {
+		return []string{literal}
+	}
+	matches := sh.glob(pattern)
+	if len(matches) == 0 {
+		return []string{literal}
+	}
+	return matches
+}
+
+func (sh *shell) glob(pattern string) []string {
+	matches := []string{""}
+	if strings.HasPrefix(pattern, "/") {
+		matches = []string{"/"}
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	join := func(dir, name string) string {
+		if dir == "" || strings.HasSuffix(dir, "/") {
+			return dir + name
+		}
+		return dir + "/" + name
+	}
+
+	comps := strings.Split(pattern, "/")
+	for i, comp := range comps {
+		var next []string
+		if !hasGlobMeta(comp) {
+			name := unescapeGlob(comp)
+			for _, m := range matches {
+				p := join(m, name)
+				if i < len(comps)-1 {
+					next = append(next, p)
+				} else if _, err := os.Lstat(sh.path(p)); err == nil {
+					next = append(next, p)
+				}
+			}
+			matches = next
+			continue
+		}
+
+		for _, m := range matches {
+			dir := m
+			if dir == "" {
+				dir = "."
+			}
+			entries, err := os.ReadDir(sh.path(dir))
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				name := e.Name()
+				if name[0] == '.' && comp[0] != '.' {
+					continue
+				}
+				if matchPattern(comp, name) {
+					next = append(next, join(m, name))
+				}
+			}
+		}
+		matches = next
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func hasGlobMeta(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+func unescapeGlob(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+// matchPattern reports whether s matches the shell pattern, in which *
+// and ? match any characters including '/'.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			s, pattern = s[n:], pattern[1:]
+		case '[':
+			if s == "" {
+				return false
+			}
+			r, n := utf8.DecodeRuneInString(s)
+			ok, rest, valid := matchBracket(pattern, r)
+			if !valid {
+				if s[0] != '[' {
+					return false
+				}
+				s, pattern = s[1:], pattern[1:]
+				continue
+			}
+			if !ok {
+				return false
+			}
+			s, pattern = s[n:], rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		}
+	}
+	return s == ""
+}
+
+// matchBracket matches r against the bracket expression at the start of
+// pattern and returns the rest of the pattern. valid is false when the
+// bracket is not closed, in which case '[' is an ordinary character.
+func matchBracket(pattern string, r rune) (matched bool, rest string, valid bool) {
+	i := 1
+	negate := i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^')
+	if negate {
+		i++
+	}
+	for first := true; ; first = false {
+		if i >= len(pattern) {
+			return false, "", false
+		}
+		if pattern[i] == ']' && !first {
+			break
+		}
+		lo, n := patternRune(pattern, i)
+		i += n
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			hi, n = patternRune(pattern, i+1)
+			i += n + 1
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+	}
+	return matched != negate, pattern[i+1:], true
+}
+
+func patternRune(pattern string, i int) (rune, int) {
+	if pattern[i] == '\\' && i+1 < len(pattern) {
+		r, n := utf8.DecodeRuneInString(pattern[i+1:])
+		return r, n + 1
+	}
+	return utf8.DecodeRuneInString(pattern[i:])
+}
@@ -0,0 +1,89 @@
+package interp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBraceExpand(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want []string
+	}{
+		{`a{b,c}d`, []string{"abd", "acd"}},
+		{`{a,b}{1,2}`, []string{"a1", "a2", "b1", "b2"}},
+		{`x{a,{b,c}}`, []string{"xa", "xb", "xc"}},
+		{`{a,}`, []string{"a", ""}},
+		{`{a}`, []string{"{a}"}},
+		{`{}`, []string{"{}"}},
+		{`"{a,b}"`, []string{`"{a,b}"`}},
+		{`\{a,b}`, []string{`\{a,b}`}},
+		{`${x:-{a,b}}`, []string{`${x:-{a,b}}`}},
+		{`'{'{a,b}`, []string{`'{'a`, `'{'b`}},
+	}
+	for _, c := range cases {
+		if got := braceExpand(c.raw); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("braceExpand(%s) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestGlobAndTilde(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.txt", ".hidden.go", "sub/d.go", "sub/e.txt", "[x]"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sh := newShell()
+	sh.dir = dir
+	sh.setVar("HOME", "/home/test")
+	sh.setVar("P", "*.txt")
+	cases := []struct {
+		raw  string
+		want []string
+	}{
+		{`*.go`, []string{"a.go", "b.go"}},
+		{`?.txt`, []string{"c.txt"}},
+		{`[ab].go`, []string{"a.go", "b.go"}},
+		{`[!a].go`, []string{"b.go"}},
+		{`.*.go`, []string{".hidden.go"}},
+		{`*/*.go`, []string{"sub/d.go"}},
+		{`sub/*`, []string{"sub/d.go", "sub/e.txt"}},
+		{dir + `/s*/e.*`, []string{dir + "/sub/e.txt"}},
+		{`$P`, []string{"c.txt"}},
+		{`"$P"`, []string{"*.txt"}},
+		{`"*".go`, []string{"*.go"}},
+		{`\*.go`, []string{"*.go"}},
+		{`\[x]`, []string{"[x]"}},
+		{`*.none`, []string{"*.none"}},
+		{`~`, []string{"/home/test"}},
+		{`~/src`, []string{"/home/test/src"}},
+		{`~+`, []string{dir}},
+		{`"~"`, []string{"~"}},
+		{`a~`, []string{"a~"}},
+		{`~nosuchuser/x`, []string{"~nosuchuser/x"}},
+	}
+	for _, c := range cases {
+		got, err := sh.expandFields(c.raw)
+		if err != nil {
+			t.Errorf("expandFields(%s) error: %v", c.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("expandFields(%s) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+
+	status, out := runScript(t, sh, "echo {b,a}.go *.txt; set -f; echo *.go")
+	if status != 0 || out != "b.go a.go c.txt\n*.go\n" {
+		t.Errorf("status %d, output %q", status, out)
+	}
+}
@@ -0,0 +1,237 @@
+// Package interp implements maxishell, a POSIX-style shell, as a library.
+// An Interpreter parses and runs command lines and keeps shell state such
+// as variables, functions and the working directory between runs.
+package interp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Interpreter runs shell scripts. Env, Dir, Name and Args are read on the
+// first call to Run or Interactive; the standard streams and OnCommand on
+// every call.
+type Interpreter struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Env holds the environment variables, all exported. If nil, the
+	// process environment is used.
+	Env map[string]string
+	// Dir is the working directory. If empty, the process's is used.
+	Dir string
+	// Name is $0 and Args are the positional parameters.
+	Name string
+	Args []string
+
+	// OnCommand, if set, is called as each simple command finishes. It may
+	// be called from several goroutines at once while a pipeline runs.
+	OnCommand func(Command)
+
+	sh *shell
+}
+
+// A Command is a simple command the interpreter has run: a builtin, a
+// function call or a child process.
+type Command struct {
+	Args   []string
+	Status int
+	// Signal names the signal that killed the command, such as "KILL",
+	// or is empty.
+	Signal string
+}
+
+func (it *Interpreter) shell() *shell {
+	if it.sh != nil {
+		return it.sh
+	}
+	sh := newShell()
+	if it.Env != nil {
+		sh.vars = make(map[string]variable, len(it.Env))
+		for name, value := range it.Env {
+			if isName(name) && name != "IFS" {
+				sh.vars[name] = variable{value: value, set: true, exported: true}
+			}
+		}
+	}
+	if it.Dir != "" {
+		sh.dir = filepath.Clean(sh.path(it.Dir))
+		sh.setVar("PWD", sh.dir)
+	}
+	if it.Name != "" {
+		sh.name = it.Name
+	}
+	sh.params = it.Args
+	it.sh = sh
+	return sh
+}
+
+func (it *Interpreter) stdio() stdio {
+	std := stdio{in: it.Stdin, out: it.Stdout, err: it.Stderr}
+	if std.in == nil {
+		std.in = strings.NewReader("")
+	}
+	if std.out == nil {
+		std.out = io.Discard
+	}
+	if std.err == nil {
+		std.err = io.Discard
+	}
+	same := std.out == std.err
+	std.out = syncWriter(std.out)
+	if same {
+		std.err = std.out
+	} else {
+		std.err = syncWriter(std.err)
+	}
+	return std
+}
+
+// lockedWriter serializes writes, since background jobs write to the
+// interpreter's output while the script goes on.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// syncWriter wraps w in a lockedWriter unless it is a file, which the
+// kernel already serializes writes to.
+func syncWriter(w io.Writer) io.Writer {
+	if _, ok := w.(*os.File); ok || w == io.Discard {
+		return w
+	}
+	return &lockedWriter{w: w}
+}
+
+// Run runs script and returns the exit status of the last command, or
+// the status given to exit. A syntax error stops the script with status
+// 2 and is returned. Cancelling ctx kills running commands, stops the
+// script and returns ctx.Err(). Background jobs may still be running when
+// Run returns; Wait waits for them.
+func (it *Interpreter) Run(ctx context.Context, script string) (int, error) {
+	sh := it.shell()
+	sh.std = it.stdio()
+	sh.ctx = ctx
+	sh.onCommand = it.OnCommand
+	sh.exiting = false
+	defer func() { sh.ctx = context.Background() }()
+
+	status, err := sh.run(script, sh.std)
+	if ctx.Err() != nil {
+		return status, ctx.Err()
+	}
+	return status, err
+}
+
+// Wait waits for the background jobs that Run started to finish. Until
+// they have, they may still be writing to Stdout and Stderr. Cancelling
+// ctx stops the wait and returns ctx.Err(); the jobs keep running unless
+// the context given to Run is cancelled.
+func (it *Interpreter) Wait(ctx context.Context) error {
+	if it.sh == nil {
+		return nil
+	}
+	sh := it.sh
+	sh.ctx = ctx
+	defer func() { sh.ctx = context.Background() }()
+	builtinWait(sh, []string{"wait"}, sh.std)
+	return ctx.Err()
+}
+
+// Incomplete reports whether script stops in the middle of a command, as
+// in an unclosed quote or an if without fi, so that more input is needed
+// before it can run.
+func (it *Interpreter) Incomplete(script string) bool {
+	_, err := it.shell().parse(script)
+	return isIncomplete(err)
+}
+
+// Exited reports whether the last call to Run ended with exit.
+func (it *Interpreter) Exited() bool {
+	return it.sh != nil && it.sh.exiting
+}
+
+// Interactive runs a read-eval-print loop on the standard streams, with
+// line editing when Stdin is a terminal, history in ~/.maxishell_history
+// and ~/.maxishellrc run first. It returns the exit status.
+func (it *Interpreter) Interactive() int {
+	sh := it.shell()
+	sh.std = it.stdio()
+	sh.interactive = true
+	histFile := ""
+	if home, ok := sh.getVar("HOME"); ok {
+		histFile = filepath.Join(home, ".maxishell_history")
+	}
+	sh.history = loadHistory(histFile)
+	ed := newLineEditor(sh.std.in, sh.std.out, sh.history)
+	ed.complete = sh.completions
+
+	sh.loadRC(sh.std)
+	if !sh.exiting {
+		sh.repl(ed, sh.std)
+	}
+	return sh.status
+}
+
+// repl reads commands with ed and runs them until end of input or exit.
+func (sh *shell) repl(ed *lineEditor, std stdio) {
+	for !sh.exiting {
+		sh.notifyJobs(std.err)
+		src, err := sh.readCommand(ed, sh.prompt("PS1", "maxishell> "), std)
+		if err == errInterrupted {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(src) == "" {
+			continue
+		}
+
+		prog, err := sh.parse(src)
+		for isIncomplete(err) {
+			more, readErr := sh.readCommand(ed, sh.prompt("PS2", "> "), std)
+			if readErr != nil {
+				break
+			}
+			src += "\n" + more
+			prog, err = sh.parse(src)
+		}
+		if err != nil {
+			fmt.Fprintln(std.err, "maxishell: syntax error:", err)
+			continue
+		}
+		sh.execList(prog, std)
+	}
+}
+
+// readCommand reads a line, applies history expansion and records it in
+// the history.
+func (sh *shell) readCommand(ed *lineEditor, prompt string, std stdio) (string, error) {
+	line, err := ed.readLine(prompt)
+	if err != nil {
+		return "", err
+	}
+	line, changed, err := ed.history.expand(line)
+	if err != nil {
+		fmt.Fprintln(std.err, "maxishell:", err)
+		return "", errInterrupted
+	}
+	if changed {
+		fmt.Fprintln(std.out, line)
+	}
+	ed.history.add(line)
+	return line, nil
+}
@@ -0,0 +1,75 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterpreterRun(t *testing.T) {
+	dir := t.TempDir()
+	var out, errOut bytes.Buffer
+	it := &Interpreter{
+		Stdin:  strings.NewReader("from stdin\n"),
+		Stdout: &out,
+		Stderr: &errOut,
+		Env:    map[string]string{"GREETING": "hello", "PATH": "/usr/bin:/bin"},
+		Dir:    dir,
+		Name:   "embedded",
+		Args:   []string{"one", "two"},
+	}
+	ctx := context.Background()
+
+	status, err := it.Run(ctx, `echo $GREETING $0 $# $1; pwd; read line; echo "$line"; sh -c 'echo $GREETING' >&2; f() { echo fn; }; X=1`)
+	want := "hello embedded 2 one\n" + dir + "\nfrom stdin\n"
+	if status != 0 || err != nil || out.String() != want || errOut.String() != "hello\n" {
+		t.Errorf("first run: %d, %v, stdout %q, stderr %q", status, err, out.String(), errOut.String())
+	}
+
+	out.Reset()
+	status, err = it.Run(ctx, "f; echo $X; env | grep -c GREETING; exit 3; echo no")
+	if status != 3 || err != nil || out.String() != "fn\n1\n1\n" {
+		t.Errorf("second run: %d, %v, %q", status, err, out.String())
+	}
+
+	out.Reset()
+	status, err = it.Run(ctx, "echo again\nif true; then")
+	if status != 2 || err == nil || err.Error() != "syntax error: 2:14: unexpected end of input" || out.String() != "again\n" {
+		t.Errorf("syntax error run: %d, %v, %q", status, err, out.String())
+	}
+}
+
+func TestInterpreterNilStreams(t *testing.T) {
+	it := &Interpreter{}
+	if status, err := it.Run(context.Background(), "echo discarded; read x"); status != 1 || err != nil {
+		t.Errorf("status %d, err %v", status, err)
+	}
+}
+
+func TestInterpreterWait(t *testing.T) {
+	var out bytes.Buffer
+	it := &Interpreter{Stdout: &out}
+	if status, err := it.Run(context.Background(), "{ sleep 0.05; echo bg; } & sh -c 'sleep 0.05; echo child' &"); status != 0 || err != nil {
+		t.Fatalf("status %d, err %v", status, err)
+	}
+	if err := it.Wait(context.Background()); err != nil || out.String() != "bg\nchild\n" && out.String() != "child\nbg\n" {
+		t.Errorf("Wait: %v, output %q", err, out.String())
+	}
+}
+
+func TestInterpreterCancel(t *testing.T) {
+	for _, src := range []string{"sleep 10; echo no", "while true; do true; done; echo no", "sleep 10 | cat; echo no", "sh -c 'sleep 10; echo grandchild'; echo no"} {
+		var out bytes.Buffer
+		it := &Interpreter{Stdout: &out, Stderr: &out}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		_, err := it.Run(ctx, src)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || out.Len() != 0 || time.Since(start) > 2*time.Second {
+			t.Errorf("%s: err %v, output %q after %v", src, err, out.String(), time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const rlimInfinity = ^uint64(0)
+
+var rlimits = []struct {
+	flag     byte
+	resource int
+	name     string
+	unit     string
+	scale    uint64
+}{
+	{'f', syscall.RLIMIT_FSIZE, "file size", "blocks, ", 1024},
+	{'n', syscall.RLIMIT_NOFILE, "open files", "", 1},
+	{'t', syscall.RLIMIT_CPU, "cpu time", "seconds, ", 1},
+	{'v', syscall.RLIMIT_AS, "virtual memory", "kbytes, ", 1024},
+}
+
+// limit returns the limit on resource for commands the shell starts.
+func (sh *shell) limit(resource int) syscall.Rlimit {
+	if lim, ok := sh.limits[resource]; ok {
+		return lim
+	}
+	var lim syscall.Rlimit
+	syscall.Getrlimit(resource, &lim)
+	return lim
+}
+
+// Commands started under limits set with ulimit, or with a umask other
+// than the process's, run through the program's own executable, which
+// sets the limits and umask in MaybeExecLimited and then executes the
+// command, so the shell itself keeps its own.
+const (
+	limitsEnv = "MAXISHELL_RLIMITS"
+	umaskEnv  = "MAXISHELL_UMASK"
+	execEnv   = "MAXISHELL_EXEC"
+)
+
+// execHook records that the program calls MaybeExecLimited, so that its
+// executable can start commands under limits.
+var execHook atomic.Bool
+
+// MaybeExecLimited must be called first thing in main by programs that
+// let scripts use ulimit. When the program has been started by the
+// interpreter to run a command under limits, it sets them and executes
+// the command in place of the program; otherwise it returns. Without it,
+// ulimit still reports and records limits but commands fail to start
+// under them, and a umask set with umask applies only to files the shell
+// creates itself.
+func MaybeExecLimited() {
+	execHook.Store(true)
+	spec, ok := os.LookupEnv(limitsEnv)
+	if !ok {
+		return
+	}
+	var resources []int
+	var limits []syscall.Rlimit
+	for _, field := range strings.Split(spec, ",") {
+		var resource int
+		var lim syscall.Rlimit
+		if _, err := fmt.Sscanf(field, "%d=%d:%d", &resource, &lim.Cur, &lim.Max); err == nil {
+			resources, limits = append(resources, resource), append(limits, lim)
+		}
+	}
+	mask := -1
+	if n, err := strconv.ParseUint(os.Getenv(umaskEnv), 8, 32); err == nil {
+		mask = int(n)
+	}
+
+	// Nothing may allocate once the limits are set, since an address space
+	// limit can leave the runtime without memory before the exec.
+	path := os.Getenv(execEnv)
+	env := removeEnv(removeEnv(removeEnv(os.Environ(), limitsEnv), umaskEnv), execEnv)
+	pathp, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		os.Exit(126)
+	}
+	argv, err := syscall.SlicePtrFromStrings(os.Args)
+	if err != nil {
+		os.Exit(126)
+	}
+	envv, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		os.Exit(126)
+	}
+	for i, resource := range resources {
+		if err := syscall.Setrlimit(resource, &limits[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "maxishell: setrlimit: %v\n", err)
+			os.Exit(126)
+		}
+	}
+	if mask >= 0 {
+		syscall.Umask(mask)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE, uintptr(unsafe.Pointer(pathp)),
+		uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+	fmt.Fprintf(os.Stderr, "maxishell: %s: %v\n", path, errno)
+	if errno == syscall.ENOENT {
+		os.Exit(127)
+	}
+	os.Exit(126)
+}
+
+// limitCommand makes cmd start through the shell's executable with the
+// limits given with ulimit and the shell's umask.
+func (sh *shell) limitCommand(cmd *exec.Cmd) error {
+	if !execHook.Load() {
+		return errors.New("cannot apply ulimit: the program does not call interp.MaybeExecLimited")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var spec []string
+	for resource, lim := range sh.limits {
+		spec = append(spec, fmt.Sprintf("%d=%d:%d", resource, lim.Cur, lim.Max))
+	}
+	cmd.Env = append(cmd.Env, execEnv+"="+cmd.Path, limitsEnv+"="+strings.Join(spec, ","),
+		fmt.Sprintf("%s=%o", umaskEnv, sh.umask))
+	cmd.Path = self
+	return nil
+}
+
+// builtinUlimit shows or sets the resource limits for commands started by
+// the shell: ulimit [-SH] [-a | -f | -n | -t | -v] [limit | unlimited].
+func builtinUlimit(sh *shell, args []string, std stdio) int {
+	soft, hard, all := false, false, false
+	which := 0
+	var value string
+	for _, arg := range args[1:] {
+		if len(arg) < 2 || arg[0] != '-' {
+			if value != "" {
+				fmt.Fprintln(std.err, "ulimit: too many arguments")
+				return 2
+			}
+			value = arg
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			switch arg[j] {
+			case 'S':
+				soft = true
+			case 'H':
+				hard = true
+			case 'a':
+				all = true
+			default:
+				i := rlimitIndex(arg[j])
+				if i < 0 {
+					fmt.Fprintf(std.err, "ulimit: -%c: invalid option\n", arg[j])
+					return 2
+				}
+				which = i
+			}
+		}
+	}
+
+	show := func(lim syscall.Rlimit, i int) string {
+		v := lim.Cur
+		if hard && !soft {
+			v = lim.Max
+		}
+		if v == rlimInfinity {
+			return "unlimited"
+		}
+		return strconv.FormatUint(v/rlimits[i].scale, 10)
+	}
+	if all {
+		for i, r := range rlimits {
+			label := fmt.Sprintf("%s (%s-%c)", r.name, r.unit, r.flag)
+			fmt.Fprintf(std.out, "%-28s %s\n", label, show(sh.limit(r.resource), i))
+		}
+		return 0
+	}
+	r := rlimits[which]
+	lim := sh.limit(r.resource)
+	if value == "" {
+		fmt.Fprintln(std.out, show(lim, which))
+		return 0
+	}
+
+	n := rlimInfinity
+	if value != "unlimited" {
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || v > rlimInfinity/r.scale {
+			fmt.Fprintf(std.err, "ulimit: %s: invalid number\n", value)
+			return 1
+		}
+		n = v * r.scale
+	}
+	next := lim
+	if soft || !hard {
+		next.Cur = n
+	}
+	if hard || !soft {
+		next.Max = n
+	}
+	if next.Cur > next.Max {
+		fmt.Fprintf(std.err, "ulimit: %s: cannot modify limit: %v\n", r.name, syscall.EINVAL)
+		return 1
+	}
+	if next.Max > lim.Max && os.Geteuid() != 0 {
+		fmt.Fprintf(std.err, "ulimit: %s: cannot modify limit: %v\n", r.name, syscall.EPERM)
+		return 1
+	}
+	sh.limits[r.resource] = next
+	return 0
+}
+
+func rlimitIndex(flag byte) int {
+	for i, r := range rlimits {
+		if r.flag == flag {
+			return i
+		}
+	}
+	return -1
+}
+
+// builtinTimeout runs a command in its own process group and sends it a
+// signal, TERM unless -s says otherwise, if it is still running after the
+// duration; with -k, KILL follows if it outlives the signal by that long.
+// The status is 124 if the command timed out.
+func builtinTimeout(sh *shell, args []string, std stdio) int {
+	const usage = "timeout: usage: timeout [-s signal] [-k duration] duration command [arg ...]"
+	sig, killAfter := syscall.SIGTERM, time.Duration(0)
+	args = args[1:]
+	for len(args) > 1 && (args[0] == "-s" || args[0] == "-k") {
+		switch args[0] {
+		case "-s":
+			s, ok := parseSignal(args[1])
+			if !ok {
+				fmt.Fprintf(std.err, "timeout: %s: invalid signal\n", args[1])
+				return 125
+			}
+			sig = s
+		case "-k":
+			d, err := parseTimeout(args[1])
+			if err != nil {
+				fmt.Fprintln(std.err, "timeout:", err)
+				return 125
+			}
+			killAfter = d
+		}
+		args = args[2:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(std.err, usage)
+		return 125
+	}
+	limit, err := parseTimeout(args[0])
+	if err != nil {
+		fmt.Fprintln(std.err, "timeout:", err)
+		return 125
+	}
+
+	sub := sh.clone()
+	sub.job = &job{started: make(chan struct{})}
+	cmd, err := sub.startExternal(args[1:], sh.environ(nil), std)
+	if err != nil {
+		fmt.Fprintln(std.err, "timeout:", err)
+		return startErrorStatus(err)
+	}
+	pgid := sub.job.pgid
+
+	var timedOut atomic.Bool
+	done := make(chan struct{})
+	if limit > 0 {
+		go func() {
+			select {
+			case <-done:
+				return
+			case <-time.After(limit):
+			}
+			timedOut.Store(true)
+			syscall.Kill(-pgid, sig)
+			if killAfter == 0 {
+				return
+			}
+			select {
+			case <-done:
+			case <-time.After(killAfter):
+				syscall.Kill(-pgid, syscall.SIGKILL)
+			}
+		}()
+	}
+	status := sh.wait(cmd)
+	close(done)
+	if timedOut.Load() && status != 128+int(syscall.SIGKILL) {
+		return 124
+	}
+	return status
+}
+
+// parseTimeout parses a duration in seconds, or in minutes, hours or days
+// with an m, h or d suffix. Fractions are allowed.
+func parseTimeout(s string) (time.Duration, error) {
+	unit := time.Second
+	num := s
+	if s != "" {
+		switch s[len(s)-1] {
+		case 's':
+			num = s[:len(s)-1]
+		case 'm':
+			unit, num = time.Minute, s[:len(s)-1]
+		case 'h':
+			unit, num = time.Hour, s[:len(s)-1]
+		case 'd':
+			unit, num = 24*time.Hour, s[:len(s)-1]
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%s: invalid time interval", s)
+	}
+	return time.Duration(f * float64(unit)), nil
+}
+
+// cpuTimes adds up the CPU time of the commands waited for while a timed
+// pipeline runs.
+type cpuTimes struct {
+	mu   sync.Mutex
+	user time.Duration
+	sys  time.Duration
+}
+
+func (t *cpuTimes) add(user, sys time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.user += user
+	t.sys += sys
+	t.mu.Unlock()
+}
+
+// wait waits for cmd, counts its CPU time towards any timed pipeline and
+// reports it to OnCommand.
+func (sh *shell) wait(cmd *exec.Cmd) int {
+	status := waitCommand(cmd)
+	if ps := cmd.ProcessState; ps != nil {
+		sh.times.add(ps.UserTime(), ps.SystemTime())
+	}
+	sh.report(cmd.Args, status, cmd.ProcessState)
+	return status
+}
+
+// execTimed runs a pipeline prefixed with time and reports the real, user
+// and sys time it took to stderr.
+func (sh *shell) execTimed(pl *pipeline, std stdio) int {
+	outer := sh.times
+	times := &cpuTimes{}
+	sh.times = times
+	untimed := *pl
+	untimed.timed = false
+	start := time.Now()
+	status := sh.execPipeline(&untimed, std)
+	elapsed := time.Since(start)
+	sh.times = outer
+	outer.add(times.user, times.sys)
+
+	reportTime(std.err, pl.timePosix, elapsed, times.user, times.sys)
+	return status
+}
+
+func reportTime(w io.Writer, posix bool, elapsed, user, sys time.Duration) {
+	if posix {
+		fmt.Fprintf(w, "real %.2f\nuser %.2f\nsys %.2f\n", elapsed.Seconds(), user.Seconds(), sys.Seconds())
+		return
+	}
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%dm%.3fs", int(d.Minutes()), (d % time.Minute).Seconds())
+	}
+	fmt.Fprintf(w, "\nreal\t%s\nuser\t%s\nsys\t%s\n", format(elapsed), format(user), format(sys))
+}
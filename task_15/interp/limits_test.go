@@ -0,0 +1,95 @@
+package interp
+
+import (
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	MaybeExecLimited()
+	os.Exit(m.Run())
+}
+
+func TestUlimit(t *testing.T) {
+	cases := []struct {
+		src    string
+		want   string
+		status int
+	}{
+		{"ulimit -n 64; ulimit -n; sh -c 'ulimit -n'", "64\n64\n", 0},
+		{"ulimit -n 64; (ulimit -n 32; sh -c 'ulimit -n'); sh -c 'ulimit -n'", "32\n64\n", 0},
+		{"ulimit -n 64; ulimit -Sn 16; sh -c 'ulimit -Sn; ulimit -Hn'; ulimit -Hn", "16\n64\n64\n", 0},
+		{"ulimit -v 102400; ulimit -v; sh -c 'ulimit -v'", "102400\n102400\n", 0},
+		{"ulimit -t unlimited; ulimit -t", "unlimited\n", 0},
+		{"ulimit -n 64; ulimit -Sn 128", "ulimit: open files: cannot modify limit: invalid argument\n", 1},
+		{"ulimit -n lots", "ulimit: lots: invalid number\n", 1},
+		{"ulimit -x", "ulimit: -x: invalid option\n", 2},
+		{"ulimit -t 1; sh -c 'while true; do true; done'; echo $?", "137\n", 0},
+	}
+	for _, c := range cases {
+		status, out := runScript(t, newShell(), c.src)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: status %d, output %q; want %d, %q", c.src, status, out, c.status, c.want)
+		}
+	}
+
+	_, out := runScript(t, newShell(), "ulimit -a")
+	if !regexp.MustCompile(`(?m)^open files \(-n\) +\d+$`).MatchString(out) {
+		t.Errorf("ulimit -a output %q", out)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"timeout 0.2 sleep 5; echo $?", "124\n"},
+		{"timeout 5 true; echo $?", "0\n"},
+		{"timeout 5 sh -c 'exit 3'; echo $?", "3\n"},
+		{"timeout -s KILL 0.1 sleep 5; echo $?", "137\n"},
+		{"timeout -s INT -k 0.1 0.1 sh -c 'trap \"\" INT; sleep 5'; echo $?", "137\n"},
+		{"timeout 0.2 sh -c 'sleep 5 & sleep 5; wait'; echo $?", "124\n"},
+		{"timeout 0 true; echo $?", "0\n"},
+		{"timeout 1 no-such-command; echo $?", "timeout: no-such-command: command not found\n127\n"},
+		{"timeout 1x true; echo $?", "timeout: 1x: invalid time interval\n125\n"},
+		{"timeout 1; echo $?", "timeout: usage: timeout [-s signal] [-k duration] duration command [arg ...]\n125\n"},
+	}
+	for _, c := range cases {
+		start := time.Now()
+		_, out := runScript(t, newShell(), c.src)
+		if out != c.want || time.Since(start) > 3*time.Second {
+			t.Errorf("%s: output %q after %v; want %q", c.src, out, time.Since(start), c.want)
+		}
+	}
+
+	for _, d := range []struct {
+		in   string
+		want time.Duration
+	}{{"1.5", 1500 * time.Millisecond}, {"2s", 2 * time.Second}, {"0.5m", 30 * time.Second}, {"1h", time.Hour}, {"1d", 24 * time.Hour}} {
+		if got, err := parseTimeout(d.in); err != nil || got != d.want {
+			t.Errorf("parseTimeout(%q) = %v, %v; want %v", d.in, got, err, d.want)
+		}
+	}
+}
+
+func TestTimeKeyword(t *testing.T) {
+	sh := newShell()
+	status, out := runScript(t, sh, "time sleep 0.1")
+	if status != 0 || !regexp.MustCompile(`^\nreal\t0m0\.1\d\ds\nuser\t0m\d\.\d{3}s\nsys\t0m\d\.\d{3}s\n$`).MatchString(out) {
+		t.Errorf("time: status %d, output %q", status, out)
+	}
+
+	status, out = runScript(t, sh, "time -p ! true | sh -c 'i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done; echo done'")
+	m := regexp.MustCompile(`^done\nreal (\d+\.\d\d)\nuser (\d+\.\d\d)\nsys \d+\.\d\d\n$`).FindStringSubmatch(out)
+	if status != 1 || m == nil || m[2] == "0.00" {
+		t.Errorf("time -p: status %d, output %q", status, out)
+	}
+
+	status, out = runScript(t, sh, "f() { time false; }; f 2>/dev/null; echo $?")
+	if status != 0 || out != "1\n" {
+		t.Errorf("time in function: status %d, output %q", status, out)
+	}
+}
@@ -0,0 +1,565 @@
+package interp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+	"unsafe"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyBackspace = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCtrlK     = 11
+	keyCR        = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEsc       = 27
+	keyDelete    = 127
+)
+
+// Keys decoded from escape sequences, outside the Unicode range.
+const (
+	keyUp rune = unicode.MaxRune + 1 + iota
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDeleteForward
+	keyUnknown
+)
+
+// lineEditor reads lines from a terminal in raw mode with cursor movement,
+// history and completion. When in is not a terminal it falls back to plain
+// line reading.
+type lineEditor struct {
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	history  *history
+	complete func(line string) (int, []string)
+
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+// newLineEditor returns an editor reading from in. Editing needs in to be
+// a terminal; otherwise lines are read as they are.
+func newLineEditor(in io.Reader, out io.Writer, h *history) *lineEditor {
+	if h == nil {
+		h = &history{max: 1000}
+	}
+	fd := -1
+	if f, ok := in.(*os.File); ok {
+		fd = int(f.Fd())
+	}
+	return &lineEditor{fd: fd, in: bufio.NewReader(in), out: out, history: h}
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts the terminal into raw mode and returns a function that
+// restores the previous settings.
+func makeRaw(fd int) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Cflag |= syscall.CS8
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(fd, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+// readLine prints prompt and returns the next line without its newline.
+// It returns io.EOF on Ctrl-D at an empty line and errInterrupted on
+// Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF {
+			if line == "" {
+				fmt.Fprintln(e.out)
+				return "", err
+			}
+			err = nil
+		}
+		return strings.TrimSuffix(line, "\n"), err
+	}
+	defer restore()
+
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	histIndex := e.history.len()
+	saved := ""
+	lastTab := false
+	e.refresh()
+
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+		tab := key == keyTab
+		switch key {
+		case keyCR, keyLF:
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.buf), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteForward()
+		case keyCtrlA, keyHome:
+			e.pos = 0
+		case keyCtrlE, keyEnd:
+			e.pos = len(e.buf)
+		case keyCtrlB, keyLeft:
+			e.pos = max(e.pos-1, 0)
+		case keyCtrlF, keyRight:
+			e.pos = min(e.pos+1, len(e.buf))
+		case keyBackspace, keyDelete:
+			if e.pos > 0 {
+				e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+				e.pos--
+			}
+		case keyDeleteForward:
+			e.deleteForward()
+		case keyCtrlK:
+			e.buf = e.buf[:e.pos]
+		case keyCtrlU:
+			e.buf = append([]rune{}, e.buf[e.pos:]...)
+			e.pos = 0
+		case keyCtrlW:
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+			e.pos = start
+		case keyCtrlP, keyUp, keyCtrlN, keyDown:
+			next := histIndex - 1
+			if key == keyCtrlN || key == keyDown {
+				next = histIndex + 1
+			}
+			if next < 0 || next > e.history.len() {
+				break
+			}
+			if histIndex == e.history.len() {
+				saved = string(e.buf)
+			}
+			histIndex = next
+			if histIndex == e.history.len() {
+				e.setLine(saved)
+			} else {
+				e.setLine(e.history.entries[histIndex])
+			}
+		case keyCtrlR:
+			line, done, err := e.reverseSearch()
+			if err != nil {
+				return "", err
+			}
+			if done {
+				return line, nil
+			}
+		case keyTab:
+			e.completeWord(lastTab)
+		default:
+			if key >= ' ' && key <= unicode.MaxRune {
+				e.buf = append(e.buf[:e.pos], append([]rune{key}, e.buf[e.pos:]...)...)
+				e.pos++
+			}
+		}
+		lastTab = tab
+		e.refresh()
+	}
+}
+
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != keyEsc {
+		return r, err
+	}
+	b, err := e.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != '[' && b != 'O' {
+		return keyUnknown, nil
+	}
+	var seq []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		seq = append(seq, c)
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		return keyUp, nil
+	case "B":
+		return keyDown, nil
+	case "C":
+		return keyRight, nil
+	case "D":
+		return keyLeft, nil
+	case "H", "1~", "7~":
+		return keyHome, nil
+	case "F", "4~", "8~":
+		return keyEnd, nil
+	case "3~":
+		return keyDeleteForward, nil
+	}
+	return keyUnknown, nil
+}
+
+func (e *lineEditor) refresh() {
+	var b strings.Builder
+	b.WriteString("\r" + e.prompt + string(e.buf) + "\x1b[K")
+	if n := len(e.buf) - e.pos; n > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", n)
+	}
+	io.WriteString(e.out, b.String())
+}
+
+func (e *lineEditor) setLine(s string) {
+	e.buf = []rune(s)
+	e.pos = len(e.buf)
+}
+
+func (e *lineEditor) deleteForward() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+// reverseSearch runs an incremental Ctrl-R search. It returns the accepted
+// line with done set when Enter was pressed; otherwise the match is left in
+// the buffer for further editing.
+func (e *lineEditor) reverseSearch() (string, bool, error) {
+	original := string(e.buf)
+	query := ""
+	index := e.history.len()
+	match := ""
+	search := func(from int) {
+		for i := from; i >= 0; i-- {
+			if strings.Contains(e.history.entries[i], query) {
+				index, match = i, e.history.entries[i]
+				return
+			}
+		}
+	}
+
+	for {
+		fmt.Fprintf(e.out, "\r(reverse-i-search)`%s': %s\x1b[K", query, match)
+		key, err := e.readKey()
+		if err != nil {
+			return "", false, err
+		}
+		switch key {
+		case keyCtrlR:
+			search(index - 1)
+		case keyBackspace, keyDelete:
+			if query != "" {
+				q := []rune(query)
+				query = string(q[:len(q)-1])
+				search(e.history.len() - 1)
+			}
+		case keyCtrlG, keyCtrlC:
+			e.setLine(original)
+			return "", false, nil
+		case keyCR, keyLF:
+			fmt.Fprint(e.out, "\r"+e.prompt+match+"\x1b[K\r\n")
+			return match, true, nil
+		default:
+			if key >= ' ' && key <= unicode.MaxRune {
+				query += string(key)
+				search(min(index, e.history.len()-1))
+				continue
+			}
+			e.setLine(match)
+			return "", false, nil
+		}
+	}
+}
+
+// completeWord completes the word before the cursor. With several
+// candidates it inserts their common prefix, and lists them when there
+// is nothing to insert or Tab was pressed twice.
+func (e *lineEditor) completeWord(again bool) {
+	if e.complete == nil {
+		return
+	}
+	line := string(e.buf[:e.pos])
+	offset, candidates := e.complete(line)
+	if len(candidates) == 0 {
+		return
+	}
+	start := len([]rune(line[:offset]))
+	word := line[offset:]
+	insert := candidates[0]
+	for _, c := range candidates[1:] {
+		insert = commonPrefix(insert, c)
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(insert, "/") {
+		insert += " "
+	}
+	if len(insert) > len(word) {
+		tail := append([]rune(insert), e.buf[e.pos:]...)
+		e.buf = append(e.buf[:start], tail...)
+		e.pos = start + len([]rune(insert))
+		return
+	}
+	if len(candidates) > 1 || again {
+		fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+type history struct {
+	entries []string
+	file    string
+	max     int
+}
+
+func loadHistory(file string) *history {
+	h := &history{file: file, max: 1000}
+	if data, err := os.ReadFile(file); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				h.entries = append(h.entries, line)
+			}
+		}
+	}
+	if len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+	return h
+}
+
+func (h *history) len() int {
+	return len(h.entries)
+}
+
+// add records line unless it is blank or repeats the previous entry, and
+// appends it to the history file.
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" || strings.Contains(line, "\n") {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[1:]
+	}
+	if h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// expand performs !! and !n history substitution on line. Substitution
+// does not happen inside single quotes, after a backslash, or when the
+// '!' is followed by a blank, '=' or '('.
+func (h *history) expand(line string) (string, bool, error) {
+	var b strings.Builder
+	changed := false
+	inSingle := false
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case ch == '\'':
+			inSingle = !inSingle
+		case ch == '\\' && !inSingle && i+1 < len(line):
+			b.WriteByte(ch)
+			i++
+			ch = line[i]
+		case ch == '!' && !inSingle && i+1 < len(line) && strings.IndexByte(" \t=(", line[i+1]) < 0 && (i == 0 || line[i-1] != '$'):
+			j := i + 1
+			var n int
+			if line[j] == '!' {
+				n = len(h.entries)
+				j++
+			} else {
+				if line[j] == '-' {
+					j++
+				}
+				for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+					j++
+				}
+				num, err := strconv.Atoi(line[i+1 : j])
+				if err != nil {
+					break
+				}
+				n = num
+				if num < 0 {
+					n = len(h.entries) + 1 + num
+				}
+			}
+			if n < 1 || n > len(h.entries) {
+				return "", false, fmt.Errorf("%s: event not found", line[i:j])
+			}
+			b.WriteString(h.entries[n-1])
+			changed = true
+			i = j - 1
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String(), changed, nil
+}
+
+func builtinHistory(sh *shell, args []string, std stdio) int {
+	if sh.history == nil {
+		return 0
+	}
+	for i, line := range sh.history.entries {
+		fmt.Fprintf(std.out, "%5d  %s\n", i+1, line)
+	}
+	return 0
+}
+
+// completions returns the offset of the last word of line and the
+// candidates for it: commands in command position, file names otherwise.
+func (sh *shell) completions(line string) (int, []string) {
+	start := len(line)
+	for start > 0 && !isMeta(line[start-1]) {
+		start--
+	}
+	word := line[start:]
+	before := strings.TrimRight(line[:start], " \t")
+	command := before == "" || strings.ContainsAny(before[len(before)-1:], ";|&(")
+
+	seen := make(map[string]bool)
+	var candidates []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	if command && !strings.Contains(word, "/") {
+		for _, name := range builtins {
+			if strings.HasPrefix(name, word) {
+				add(name)
+			}
+		}
+		for name := range sh.funcs {
+			if strings.HasPrefix(name, word) {
+				add(name)
+			}
+		}
+		path, _ := sh.getVar("PATH")
+		for _, dir := range filepath.SplitList(path) {
+			entries, _ := os.ReadDir(sh.path(dir))
+			for _, e := range entries {
+				if !strings.HasPrefix(e.Name(), word) {
+					continue
+				}
+				if info, err := e.Info(); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+					add(e.Name())
+				}
+			}
+		}
+	}
+	if !command || strings.Contains(word, "/") || len(candidates) == 0 {
+		for _, name := range sh.fileCompletions(word) {
+			add(name)
+		}
+	}
+	sort.Strings(candidates)
+	return start, candidates
+}
+
+func (sh *shell) fileCompletions(word string) []string {
+	dir, prefix := "", word
+	if i := strings.LastIndexByte(word, '/'); i >= 0 {
+		dir, prefix = word[:i+1], word[i+1:]
+	}
+	listDir := dir
+	if listDir == "" {
+		listDir = "."
+	} else if strings.HasPrefix(listDir, "~") {
+		if home, n, ok := sh.expandTilde(listDir); ok {
+			listDir = home + listDir[n:]
+		}
+	}
+
+	entries, err := os.ReadDir(sh.path(listDir))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || (name[0] == '.' && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(sh.path(listDir), name)); err == nil && info.IsDir() {
+			name += "/"
+		}
+		names = append(names, dir+name)
+	}
+	return names
+}
@@ -0,0 +1,207 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPty returns the master and slave ends of a new pseudo-terminal.
+// Output written to the slave is drained in the background.
+func openPty(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("no pseudo-terminal:", err)
+	}
+	var unlock int32
+	var n uint32
+	if err := ioctl(int(master.Fd()), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Skip("unlockpt:", err)
+	}
+	if err := ioctl(int(master.Fd()), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		t.Skip("ptsname:", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("open slave:", err)
+	}
+	go io.Copy(io.Discard, master)
+	t.Cleanup(func() {
+		slave.Close()
+		master.Close()
+	})
+	return master, slave
+}
+
+// typeKeys puts the terminal in raw mode before writing input, so the line
+// discipline does not interpret control keys itself.
+func typeKeys(t *testing.T, master, slave *os.File, input string) {
+	t.Helper()
+	restore, err := makeRaw(int(slave.Fd()))
+	if err != nil {
+		t.Fatal("makeRaw:", err)
+	}
+	t.Cleanup(restore)
+	if _, err := master.Write([]byte(input)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLineEditorKeys(t *testing.T) {
+	hist := &history{entries: []string{"ls -l", "echo one"}, max: 1000}
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"echo hi\x1b[D\x1b[DX\r", "echo Xhi"},
+		{"abc\x01X\x05Y\r", "XabcY"},
+		{"abc\x1b[HX\x1b[FY\r", "XabcY"},
+		{"one two three\x17\x17four\r", "one four"},
+		{"abc def\x02\x02\x02\x15X\r", "Xdef"},
+		{"abcd\x02\x02\x0b\r", "ab"},
+		{"abc\x7f\x7fz\r", "az"},
+		{"abc\x01\x1b[3~\x04\r", "c"},
+		{"héllo\x02\x02\x02\x7f\r", "hllo"},
+		{"\x1b[A\r", "echo one"},
+		{"\x1b[A\x1b[A\x1b[A\r", "ls -l"},
+		{"typed\x10\x0e\r", "typed"},
+		{"\x12ls\r", "ls -l"},
+		{"\x12o\x12\r", "echo one"},
+		{"\x12one\x1b[C!\r", "echo one!"},
+		{"keep\x12zzz\x07\r", "keep"},
+	}
+	for _, c := range cases {
+		master, slave := openPty(t)
+		typeKeys(t, master, slave, c.input)
+		ed := newLineEditor(slave, slave, hist)
+		got, err := ed.readLine("$ ")
+		if err != nil || got != c.want {
+			t.Errorf("input %q: got %q, %v; want %q", c.input, got, err, c.want)
+		}
+	}
+
+	master, slave := openPty(t)
+	typeKeys(t, master, slave, "abc\x03\x04")
+	ed := newLineEditor(slave, slave, hist)
+	if _, err := ed.readLine("$ "); err != errInterrupted {
+		t.Errorf("Ctrl-C: got %v, want errInterrupted", err)
+	}
+	if _, err := ed.readLine("$ "); err != io.EOF {
+		t.Errorf("Ctrl-D: got %v, want EOF", err)
+	}
+}
+
+func TestLineEditorCompletion(t *testing.T) {
+	master, slave := openPty(t)
+	typeKeys(t, master, slave, "ec\t x\t\r")
+	ed := newLineEditor(slave, slave, nil)
+	ed.complete = func(line string) (int, []string) {
+		start := strings.LastIndexByte(line, ' ') + 1
+		switch line[start:] {
+		case "ec":
+			return start, []string{"echo"}
+		case "x":
+			return start, []string{"xa/", "xab/"}
+		}
+		return start, nil
+	}
+	if got, err := ed.readLine("$ "); err != nil || got != "echo  xa" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"bin/mytool", "src/main.go", "src/more.go", ".hidden"} {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, nil, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sh := newShell()
+	sh.dir = dir
+	sh.setVar("PATH", filepath.Join(dir, "bin"))
+	sh.funcs["myfunc"] = &braceGroup{}
+
+	cases := []struct {
+		line  string
+		start int
+		want  []string
+	}{
+		{"my", 0, []string{"myfunc", "mytool"}},
+		{"ech", 0, []string{"echo"}},
+		{"ls && ex", 6, []string{"exit", "export"}},
+		{"cat s", 4, []string{"src/"}},
+		{"cat src/m", 4, []string{"src/main.go", "src/more.go"}},
+		{"cat <src/ma", 5, []string{"src/main.go"}},
+		{"cat .h", 4, []string{".hidden"}},
+		{"cat ", 4, []string{"bin/", "src/"}},
+		{"./s", 0, []string{"./src/"}},
+	}
+	for _, c := range cases {
+		start, got := sh.completions(c.line)
+		if start != c.start || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("completions(%q) = %d, %q; want %d, %q", c.line, start, got, c.start, c.want)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	h := loadHistory(file)
+	for _, line := range []string{"ls", "ls", " ", "echo 'a b'", "cd /tmp"} {
+		h.add(line)
+	}
+	if want := []string{"ls", "echo 'a b'", "cd /tmp"}; !reflect.DeepEqual(h.entries, want) {
+		t.Fatalf("entries = %q, want %q", h.entries, want)
+	}
+	if again := loadHistory(file); !reflect.DeepEqual(again.entries, h.entries) {
+		t.Errorf("reloaded entries = %q", again.entries)
+	}
+
+	cases := []struct {
+		line    string
+		want    string
+		changed bool
+	}{
+		{"!!", "cd /tmp", true},
+		{"sudo !! && !1", "sudo cd /tmp && ls", true},
+		{"!-2 x", "echo 'a b' x", true},
+		{"echo '!!' \\!! ! != $!", "echo '!!' \\!! ! != $!", false},
+		{"echo hi!", "echo hi!", false},
+	}
+	for _, c := range cases {
+		got, changed, err := h.expand(c.line)
+		if err != nil || got != c.want || changed != c.changed {
+			t.Errorf("expand(%q) = %q, %v, %v; want %q, %v", c.line, got, changed, err, c.want, c.changed)
+		}
+	}
+	if _, _, err := h.expand("!7"); err == nil || err.Error() != "!7: event not found" {
+		t.Errorf("expand(!7) error = %v", err)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	master, slave := openPty(t)
+	typeKeys(t, master, slave, "echo hi\r!!\rif true\rthen echo yes; fi\r!9\rexit\recho unreachable\r")
+
+	sh := newShell()
+	sh.history = loadHistory(filepath.Join(t.TempDir(), "history"))
+	ed := newLineEditor(slave, slave, sh.history)
+	var out bytes.Buffer
+	sh.repl(ed, stdio{in: strings.NewReader(""), out: &out, err: &out})
+
+	want := "hi\necho hi\nhi\nyes\nmaxishell: !9: event not found\n"
+	if out.String() != want || !sh.exiting {
+		t.Errorf("output %q (exiting %v), want %q", out.String(), sh.exiting, want)
+	}
+}
@@ -0,0 +1,1150 @@
+package interp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type word struct {
+	raw string
+	pos int
+}
+
+type redirect struct {
+	fd     int
+	op     string
+	target word
+	delim  string
+	quoted bool
+	body   string
+	pos    int
+}
+
+type command interface {
+	Pos() int
+	Redirects() []*redirect
+}
+
+type list struct {
+	items []*andOr
+}
+
+type andOr struct {
+	pipelines  []*pipeline
+	ops        []string
+	background bool
+}
+
+type pipeline struct {
+	timed     bool
+	timePosix bool
+	bang      bool
+	cmds      []command
+	pos       int
+}
+
+type simpleCommand struct {
+	assigns   []word
+	args      []word
+	redirects []*redirect
+	pos       int
+}
+
+type subshell struct {
+	body      *list
+	redirects []*redirect
+	pos       int
+}
+
+type braceGroup struct {
+	body      *list
+	redirects []*redirect
+	pos       int
+}
+
+type ifClause struct {
+	conds     []*list
+	bodies    []*list
+	elseBody  *list
+	redirects []*redirect
+	pos       int
+}
+
+type loopClause struct {
+	until     bool
+	cond      *list
+	body      *list
+	redirects []*redirect
+	pos       int
+}
+
+type forClause struct {
+	name      string
+	words     []word
+	hasIn     bool
+	body      *list
+	redirects []*redirect
+	pos       int
+}
+
+type caseItem struct {
+	patterns []word
+	body     *list
+}
+
+type caseClause struct {
+	word      word
+	items     []*caseItem
+	redirects []*redirect
+	pos       int
+}
+
+type funcDef struct {
+	name string
+	body command
+	pos  int
+}
+
+func (c *simpleCommand) Pos() int { return c.pos }
+func (c *subshell) Pos() int      { return c.pos }
+func (c *braceGroup) Pos() int    { return c.pos }
+func (c *ifClause) Pos() int      { return c.pos }
+func (c *loopClause) Pos() int    { return c.pos }
+func (c *forClause) Pos() int     { return c.pos }
+func (c *caseClause) Pos() int    { return c.pos }
+func (c *funcDef) Pos() int       { return c.pos }
+
+func (c *simpleCommand) Redirects() []*redirect { return c.redirects }
+func (c *subshell) Redirects() []*redirect      { return c.redirects }
+func (c *braceGroup) Redirects() []*redirect    { return c.redirects }
+func (c *ifClause) Redirects() []*redirect      { return c.redirects }
+func (c *loopClause) Redirects() []*redirect    { return c.redirects }
+func (c *forClause) Redirects() []*redirect     { return c.redirects }
+func (c *caseClause) Redirects() []*redirect    { return c.redirects }
+func (c *funcDef) Redirects() []*redirect       { return nil }
+
+type syntaxError struct {
+	line       int
+	col        int
+	msg        string
+	incomplete bool
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.line, e.col, e.msg)
+}
+
+func isIncomplete(err error) bool {
+	se, ok := err.(*syntaxError)
+	return ok && se.incomplete
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokNewline
+	tokSemi
+	tokDSemi
+	tokAmp
+	tokAndIf
+	tokOrIf
+	tokPipe
+	tokLParen
+	tokRParen
+	tokRedirect
+)
+
+type token struct {
+	kind    tokenKind
+	val     string
+	fd      int
+	pos     int
+	aliases []string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokNewline:
+		return "newline"
+	}
+	return "'" + t.val + "'"
+}
+
+var redirectOps = []string{"&>>", "&>", "<<<", "<<-", "<<", "<&", "<>", "<", ">>", ">&", ">|", ">"}
+
+type lexer struct {
+	src      string
+	pos      int
+	heredocs []*redirect
+	err      *syntaxError
+}
+
+func (l *lexer) errorf(pos int, incomplete bool, format string, args ...any) {
+	if l.err != nil {
+		return
+	}
+	line, col := lineCol(l.src, pos)
+	l.err = &syntaxError{line: line, col: col, msg: fmt.Sprintf(format, args...), incomplete: incomplete}
+}
+
+func lineCol(src string, pos int) (int, int) {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	line := 1 + strings.Count(src[:pos], "\n")
+	col := pos - strings.LastIndex(src[:pos], "\n")
+	return line, col
+}
+
+func isMeta(ch byte) bool {
+	switch ch {
+	case ' ', '\t', '\n', ';', '&', '|', '(', ')', '<', '>':
+		return true
+	}
+	return false
+}
+
+func (l *lexer) next() token {
+	for l.pos < len(l.src) {
+		ch := l.src[l.pos]
+		if ch == ' ' || ch == '\t' {
+			l.pos++
+		} else if ch == '\\' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '\n' {
+			l.pos += 2
+		} else if ch == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		} else {
+			break
+		}
+	}
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		if len(l.heredocs) > 0 {
+			l.errorf(start, true, "here-document delimited by end-of-file (wanted '%s')", l.heredocs[0].delim)
+		}
+		return token{kind: tokEOF, pos: start}
+	}
+
+	s := l.src[l.pos:]
+	switch {
+	case s[0] == '\n':
+		l.pos++
+		l.readHeredocs()
+		return token{kind: tokNewline, val: "\n", pos: start}
+	case strings.HasPrefix(s, ";;"):
+		l.pos += 2
+		return token{kind: tokDSemi, val: ";;", pos: start}
+	case s[0] == ';':
+		l.pos++
+		return token{kind: tokSemi, val: ";", pos: start}
+	case strings.HasPrefix(s, "&&"):
+		l.pos += 2
+		return token{kind: tokAndIf, val: "&&", pos: start}
+	case strings.HasPrefix(s, "||"):
+		l.pos += 2
+		return token{kind: tokOrIf, val: "||", pos: start}
+	case s[0] == '|':
+		l.pos++
+		return token{kind: tokPipe, val: "|", pos: start}
+	case s[0] == '(':
+		l.pos++
+		return token{kind: tokLParen, val: "(", pos: start}
+	case s[0] == ')':
+		l.pos++
+		return token{kind: tokRParen, val: ")", pos: start}
+	case s[0] == '<' || s[0] == '>' || strings.HasPrefix(s, "&>"):
+		return l.redirectOp(-1, start)
+	case s[0] == '&':
+		l.pos++
+		return token{kind: tokAmp, val: "&", pos: start}
+	}
+
+	raw := l.scanWord()
+	if isDigits(raw) && l.pos < len(l.src) && (l.src[l.pos] == '<' || l.src[l.pos] == '>') {
+		fd := 0
+		for i := 0; i < len(raw); i++ {
+			fd = fd*10 + int(raw[i]-'0')
+			if fd > 255 {
+				break
+			}
+		}
+		return l.redirectOp(fd, start)
+	}
+	return token{kind: tokWord, val: raw, pos: start}
+}
+
+func (l *lexer) redirectOp(fd int, start int) token {
+	s := l.src[l.pos:]
+	for _, op := range redirectOps {
+		if !strings.HasPrefix(s, op) || (fd >= 0 && op[0] == '&') {
+			continue
+		}
+		l.pos += len(op)
+		if fd < 0 {
+			fd = 1
+			if op[0] == '<' {
+				fd = 0
+			}
+		}
+		return token{kind: tokRedirect, val: op, fd: fd, pos: start}
+	}
+	l.pos++
+	return token{kind: tokAmp, val: "&", pos: start}
+}
+
+func (l *lexer) scanWord() string {
+	start := l.pos
+	for l.pos < len(l.src) && !isMeta(l.src[l.pos]) {
+		switch l.src[l.pos] {
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				l.errorf(l.pos, true, "unexpected end of input after '\\'")
+				l.pos++
+				break
+			}
+			l.pos += 2
+		case '\'':
+			l.scanSingle()
+		case '"':
+			l.scanDouble()
+		case '`':
+			l.scanBackquote()
+		case '$':
+			l.scanDollar()
+		default:
+			l.pos++
+		}
+	}
+	return l.src[start:l.pos]
+}
+
+func (l *lexer) scanSingle() {
+	end := strings.IndexByte(l.src[l.pos+1:], '\'')
+	if end < 0 {
+		l.errorf(l.pos, true, "unterminated single quote")
+		l.pos = len(l.src)
+		return
+	}
+	l.pos += end + 2
+}
+
+func (l *lexer) scanDouble() {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '"':
+			l.pos++
+			return
+		case '\\':
+			l.pos += 2
+		case '`':
+			l.scanBackquote()
+		case '$':
+			l.scanDollar()
+		default:
+			l.pos++
+		}
+	}
+	l.errorf(start, true, "unterminated double quote")
+	l.pos = len(l.src)
+}
+
+func (l *lexer) scanBackquote() {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '`':
+			l.pos++
+			return
+		case '\\':
+			l.pos += 2
+		default:
+			l.pos++
+		}
+	}
+	l.errorf(start, true, "unterminated backquote")
+	l.pos = len(l.src)
+}
+
+func (l *lexer) scanDollar() {
+	start := l.pos
+	s := l.src[l.pos:]
+	switch {
+	case strings.HasPrefix(s, "$(("):
+		l.pos += 3
+		depth := 2
+		for l.pos < len(l.src) && depth > 0 {
+			switch l.src[l.pos] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case '\\':
+				l.pos++
+			case '\'':
+				l.scanSingle()
+				continue
+			case '"':
+				l.scanDouble()
+				continue
+			case '$':
+				l.scanDollar()
+				continue
+			}
+			l.pos++
+		}
+		if depth > 0 {
+			l.errorf(start, true, "unterminated arithmetic expansion")
+			l.pos = len(l.src)
+		}
+	case strings.HasPrefix(s, "$("):
+		sub := &parser{lex: lexer{src: l.src, pos: l.pos + 2}}
+		sub.advance()
+		sub.parseList()
+		if sub.err() == nil && sub.tok.kind != tokRParen {
+			sub.unexpected()
+		}
+		if err := sub.err(); err != nil {
+			if l.err == nil {
+				l.err = err
+			}
+			l.pos = len(l.src)
+			return
+		}
+		l.pos = sub.lex.pos
+	case strings.HasPrefix(s, "${"):
+		l.pos += 2
+		for l.pos < len(l.src) {
+			switch l.src[l.pos] {
+			case '}':
+				l.pos++
+				return
+			case '\\':
+				l.pos += 2
+			case '\'':
+				l.scanSingle()
+			case '"':
+				l.scanDouble()
+			case '`':
+				l.scanBackquote()
+			case '$':
+				l.scanDollar()
+			default:
+				l.pos++
+			}
+		}
+		l.errorf(start, true, "unterminated parameter expansion")
+		l.pos = len(l.src)
+	default:
+		l.pos++
+	}
+}
+
+func (l *lexer) readHeredocs() {
+	pending := l.heredocs
+	l.heredocs = nil
+	for _, r := range pending {
+		var body strings.Builder
+		for {
+			if l.pos >= len(l.src) {
+				l.errorf(r.pos, true, "here-document delimited by end-of-file (wanted '%s')", r.delim)
+				return
+			}
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			line := l.src[l.pos:]
+			if end >= 0 {
+				line = line[:end]
+				l.pos += end + 1
+			} else {
+				l.pos = len(l.src)
+			}
+			if r.op == "<<-" {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == r.delim {
+				break
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+		r.body = body.String()
+	}
+}
+
+type parser struct {
+	lex       lexer
+	tok       token
+	queue     []token
+	aliases   map[string]string
+	aliasNext bool
+}
+
+func parse(src string) (*list, error) {
+	return parseAliases(src, nil)
+}
+
+// parseAliases parses src, expanding aliases in command position.
+func parseAliases(src string, aliases map[string]string) (*list, error) {
+	p := newParser(src, aliases)
+	l := p.parseList()
+	if p.err() == nil && p.tok.kind != tokEOF {
+		p.unexpected()
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func newParser(src string, aliases map[string]string) *parser {
+	p := &parser{lex: lexer{src: src}, aliases: aliases}
+	p.advance()
+	return p
+}
+
+// parseLine parses the commands up to the end of the next non-empty line,
+// so that a script can be run one line at a time. It returns nil at the
+// end of the input.
+func (p *parser) parseLine() (*list, error) {
+	p.skipNewlines()
+	if p.err() == nil && p.tok.kind == tokEOF {
+		return nil, nil
+	}
+	l := &list{}
+	for p.err() == nil && p.tok.kind != tokEOF && p.tok.kind != tokNewline {
+		ao := p.parseAndOr()
+		if p.err() != nil {
+			break
+		}
+		l.items = append(l.items, ao)
+		switch p.tok.kind {
+		case tokAmp:
+			ao.background = true
+			p.advance()
+		case tokSemi:
+			p.advance()
+		case tokNewline, tokEOF:
+		default:
+			p.unexpected()
+		}
+	}
+	if err := p.err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (p *parser) advance() {
+	if len(p.queue) > 0 {
+		p.tok, p.queue = p.queue[0], p.queue[1:]
+		return
+	}
+	p.tok = p.lex.next()
+}
+
+// expandAlias replaces the current token with the tokens of its alias
+// value, repeatedly while the result starts with another alias. A token
+// produced by an alias is never expanded by that same alias again, which
+// stops loops such as alias ls='ls -F'.
+func (p *parser) expandAlias() {
+	for p.err() == nil && p.tok.kind == tokWord {
+		value, ok := p.aliases[p.tok.val]
+		if !ok || slices.Contains(p.tok.aliases, p.tok.val) {
+			return
+		}
+		from := append(slices.Clip(p.tok.aliases), p.tok.val)
+		sub := lexer{src: value}
+		var toks []token
+		for {
+			t := sub.next()
+			if sub.err != nil || t.kind == tokEOF {
+				break
+			}
+			t.pos, t.aliases = p.tok.pos, from
+			toks = append(toks, t)
+		}
+		p.aliasNext = strings.HasSuffix(value, " ") || strings.HasSuffix(value, "\t")
+		p.queue = append(toks, p.queue...)
+		p.advance()
+	}
+}
+
+func (p *parser) err() *syntaxError {
+	return p.lex.err
+}
+
+func (p *parser) unexpected() {
+	p.lex.errorf(p.tok.pos, p.tok.kind == tokEOF, "unexpected %s", p.tok)
+}
+
+func (p *parser) skipNewlines() {
+	for p.tok.kind == tokNewline && p.err() == nil {
+		p.advance()
+	}
+}
+
+func (p *parser) atReserved(words ...string) bool {
+	if p.tok.kind != tokWord {
+		return false
+	}
+	for _, w := range words {
+		if p.tok.val == w {
+			return true
+		}
+	}
+	return false
+}
+
+var closers = []string{"}", "then", "elif", "else", "fi", "do", "done", "esac"}
+
+func (p *parser) parseList() *list {
+	l := &list{}
+	p.skipNewlines()
+	for p.err() == nil {
+		if p.tok.kind == tokEOF || p.tok.kind == tokRParen || p.tok.kind == tokDSemi || p.atReserved(closers...) {
+			break
+		}
+		ao := p.parseAndOr()
+		if p.err() != nil {
+			break
+		}
+		l.items = append(l.items, ao)
+		switch p.tok.kind {
+		case tokAmp:
+			ao.background = true
+			p.advance()
+		case tokSemi, tokNewline:
+			p.advance()
+		default:
+			return l
+		}
+		p.skipNewlines()
+	}
+	return l
+}
+
+func (p *parser) parseAndOr() *andOr {
+	ao := &andOr{pipelines: []*pipeline{p.parsePipeline()}}
+	for p.err() == nil && (p.tok.kind == tokAndIf || p.tok.kind == tokOrIf) {
+		ao.ops = append(ao.ops, p.tok.val)
+		p.advance()
+		p.skipNewlines()
+		ao.pipelines = append(ao.pipelines, p.parsePipeline())
+	}
+	return ao
+}
+
+func (p *parser) parsePipeline() *pipeline {
+	pl := &pipeline{pos: p.tok.pos}
+	if p.atReserved("time") {
+		pl.timed = true
+		p.advance()
+		if p.atReserved("-p") {
+			pl.timePosix = true
+			p.advance()
+		}
+	}
+	if p.atReserved("!") {
+		pl.bang = true
+		p.advance()
+	}
+	pl.cmds = append(pl.cmds, p.parseCommand())
+	for p.err() == nil && p.tok.kind == tokPipe {
+		p.advance()
+		p.skipNewlines()
+		pl.cmds = append(pl.cmds, p.parseCommand())
+	}
+	return pl
+}
+
+func (p *parser) parseCommand() command {
+	p.aliasNext = false
+	p.expandAlias()
+	pos := p.tok.pos
+	switch {
+	case p.tok.kind == tokLParen:
+		p.advance()
+		body := p.parseCompoundBody()
+		if p.err() == nil && p.tok.kind != tokRParen {
+			p.unexpected()
+		}
+		p.advance()
+		return &subshell{body: body, redirects: p.parseRedirects(), pos: pos}
+	case p.atReserved("{"):
+		p.advance()
+		body := p.parseCompoundBody()
+		if p.err() == nil && !p.atReserved("}") {
+			p.unexpected()
+		}
+		p.advance()
+		return &braceGroup{body: body, redirects: p.parseRedirects(), pos: pos}
+	case p.atReserved("if"):
+		return p.parseIf()
+	case p.atReserved("while", "until"):
+		return p.parseLoop()
+	case p.atReserved("for"):
+		return p.parseFor()
+	case p.atReserved("case"):
+		return p.parseCase()
+	case p.tok.kind == tokWord && isReserved(p.tok.val):
+		p.unexpected()
+		return &simpleCommand{pos: pos}
+	}
+
+	c := &simpleCommand{pos: pos}
+	for p.err() == nil {
+		if p.tok.kind == tokWord && len(c.assigns) > 0 && len(c.args) == 0 && !isAssignment(p.tok.val) {
+			p.expandAlias()
+		} else if p.tok.kind == tokWord && len(c.args) > 0 && p.aliasNext && p.tok.aliases == nil {
+			p.aliasNext = false
+			p.expandAlias()
+		}
+		if p.tok.kind == tokWord && len(c.args) == 0 && isAssignment(p.tok.val) {
+			c.assigns = append(c.assigns, word{raw: p.tok.val, pos: p.tok.pos})
+			p.advance()
+		} else if p.tok.kind == tokWord {
+			c.args = append(c.args, word{raw: p.tok.val, pos: p.tok.pos})
+			p.advance()
+		} else if p.tok.kind == tokRedirect {
+			c.redirects = append(c.redirects, p.parseRedirect())
+		} else {
+			break
+		}
+	}
+	if len(c.assigns) == 0 && len(c.args) == 0 && len(c.redirects) == 0 {
+		p.unexpected()
+	}
+	if p.err() == nil && p.tok.kind == tokLParen && len(c.args) == 1 && len(c.assigns) == 0 && len(c.redirects) == 0 && isName(c.args[0].raw) {
+		return p.parseFuncDef(c.args[0].raw, pos)
+	}
+	return c
+}
+
+func (p *parser) parseFuncDef(name string, pos int) command {
+	p.advance()
+	if p.err() == nil && p.tok.kind != tokRParen {
+		p.unexpected()
+	}
+	p.advance()
+	p.skipNewlines()
+	if p.err() != nil {
+		return &funcDef{name: name, pos: pos}
+	}
+	body := p.parseCommand()
+	if _, ok := body.(*simpleCommand); ok && p.err() == nil {
+		p.lex.errorf(body.Pos(), false, "function body must be a compound command")
+	}
+	return &funcDef{name: name, body: body, pos: pos}
+}
+
+// expect consumes the reserved word w, reporting an error if the current
+// token is anything else.
+func (p *parser) expect(w string) {
+	if p.err() != nil {
+		return
+	}
+	if !p.atReserved(w) {
+		p.unexpected()
+		return
+	}
+	p.advance()
+}
+
+func (p *parser) parseIf() command {
+	c := &ifClause{pos: p.tok.pos}
+	p.advance()
+	for p.err() == nil {
+		c.conds = append(c.conds, p.parseCompoundBody())
+		p.expect("then")
+		c.bodies = append(c.bodies, p.parseCompoundBody())
+		if !p.atReserved("elif") {
+			break
+		}
+		p.advance()
+	}
+	if p.atReserved("else") {
+		p.advance()
+		c.elseBody = p.parseCompoundBody()
+	}
+	p.expect("fi")
+	c.redirects = p.parseRedirects()
+	return c
+}
+
+func (p *parser) parseLoop() command {
+	c := &loopClause{until: p.tok.val == "until", pos: p.tok.pos}
+	p.advance()
+	c.cond = p.parseCompoundBody()
+	p.expect("do")
+	c.body = p.parseCompoundBody()
+	p.expect("done")
+	c.redirects = p.parseRedirects()
+	return c
+}
+
+func (p *parser) parseFor() command {
+	c := &forClause{pos: p.tok.pos}
+	p.advance()
+	if p.err() == nil && (p.tok.kind != tokWord || !isName(p.tok.val)) {
+		p.lex.errorf(p.tok.pos, p.tok.kind == tokEOF, "expected variable name after 'for', found %s", p.tok)
+	}
+	c.name = p.tok.val
+	p.advance()
+	if p.tok.kind == tokSemi {
+		p.advance()
+	}
+	p.skipNewlines()
+	if p.atReserved("in") {
+		c.hasIn = true
+		p.advance()
+		for p.err() == nil && p.tok.kind == tokWord {
+			c.words = append(c.words, word{raw: p.tok.val, pos: p.tok.pos})
+			p.advance()
+		}
+		if p.err() == nil && p.tok.kind != tokSemi && p.tok.kind != tokNewline {
+			p.unexpected()
+		}
+		p.advance()
+		p.skipNewlines()
+	}
+	p.expect("do")
+	c.body = p.parseCompoundBody()
+	p.expect("done")
+	c.redirects = p.parseRedirects()
+	return c
+}
+
+func (p *parser) parseCase() command {
+	c := &caseClause{pos: p.tok.pos}
+	p.advance()
+	if p.err() == nil && p.tok.kind != tokWord {
+		p.lex.errorf(p.tok.pos, p.tok.kind == tokEOF, "expected word after 'case', found %s", p.tok)
+	}
+	c.word = word{raw: p.tok.val, pos: p.tok.pos}
+	p.advance()
+	p.skipNewlines()
+	p.expect("in")
+	p.skipNewlines()
+	for p.err() == nil && !p.atReserved("esac") {
+		item := &caseItem{}
+		if p.tok.kind == tokLParen {
+			p.advance()
+		}
+		for p.err() == nil {
+			if p.tok.kind != tokWord {
+				p.unexpected()
+				break
+			}
+			item.patterns = append(item.patterns, word{raw: p.tok.val, pos: p.tok.pos})
+			p.advance()
+			if p.tok.kind != tokPipe {
+				break
+			}
+			p.advance()
+		}
+		if p.err() == nil && p.tok.kind != tokRParen {
+			p.unexpected()
+		}
+		p.advance()
+		item.body = p.parseList()
+		c.items = append(c.items, item)
+		if p.tok.kind != tokDSemi {
+			break
+		}
+		p.advance()
+		p.skipNewlines()
+	}
+	p.expect("esac")
+	c.redirects = p.parseRedirects()
+	return c
+}
+
+func (p *parser) parseCompoundBody() *list {
+	body := p.parseList()
+	if p.err() == nil && len(body.items) == 0 {
+		p.unexpected()
+	}
+	return body
+}
+
+func (p *parser) parseRedirects() []*redirect {
+	var redirs []*redirect
+	for p.err() == nil && p.tok.kind == tokRedirect {
+		redirs = append(redirs, p.parseRedirect())
+	}
+	return redirs
+}
+
+func (p *parser) parseRedirect() *redirect {
+	r := &redirect{fd: p.tok.fd, op: p.tok.val, pos: p.tok.pos}
+	p.advance()
+	if p.err() != nil {
+		return r
+	}
+	if p.tok.kind != tokWord {
+		p.lex.errorf(p.tok.pos, false, "expected word after '%s', found %s", r.op, p.tok)
+		return r
+	}
+	r.target = word{raw: p.tok.val, pos: p.tok.pos}
+	if r.op == "<<" || r.op == "<<-" {
+		r.delim = unquote(r.target.raw)
+		r.quoted = r.delim != r.target.raw
+		p.lex.heredocs = append(p.lex.heredocs, r)
+	}
+	p.advance()
+	return r
+}
+
+func isReserved(s string) bool {
+	switch s {
+	case "{", "}", "!", "if", "then", "elif", "else", "fi", "while", "until", "do", "done", "for", "case", "esac", "time":
+		return true
+	}
+	return false
+}
+
+func unquote(raw string) string {
+	var buf strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch ch := raw[i]; ch {
+		case '\\':
+			if i+1 < len(raw) {
+				i++
+				if raw[i] != '\n' {
+					buf.WriteByte(raw[i])
+				}
+			}
+		case '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end < 0 {
+				end = len(raw) - i - 1
+			}
+			buf.WriteString(raw[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			for i++; i < len(raw) && raw[i] != '"'; i++ {
+				if raw[i] == '\\' && i+1 < len(raw) && strings.IndexByte("$`\"\\\n", raw[i+1]) >= 0 {
+					i++
+					if raw[i] == '\n' {
+						continue
+					}
+				}
+				buf.WriteByte(raw[i])
+			}
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+type printer struct {
+	buf      strings.Builder
+	heredocs []*redirect
+}
+
+func (l *list) String() string {
+	var p printer
+	p.list(l, false)
+	return p.buf.String()
+}
+
+func (p *printer) flushHeredocs() bool {
+	if len(p.heredocs) == 0 {
+		return false
+	}
+	p.buf.WriteByte('\n')
+	for _, r := range p.heredocs {
+		p.buf.WriteString(r.body)
+		p.buf.WriteString(r.delim)
+		p.buf.WriteByte('\n')
+	}
+	p.heredocs = nil
+	return true
+}
+
+// list prints l; when terminate is set the output always ends with a
+// separator so that a closing keyword may follow it.
+func (p *printer) list(l *list, terminate bool) {
+	for i, ao := range l.items {
+		p.andOr(ao)
+		last := i == len(l.items)-1
+		if ao.background {
+			p.buf.WriteString(" &")
+		}
+		if p.flushHeredocs() {
+			continue
+		}
+		if ao.background {
+			if !last {
+				p.buf.WriteByte(' ')
+			}
+		} else if !last {
+			p.buf.WriteString("; ")
+		} else if terminate {
+			p.buf.WriteByte(';')
+		}
+	}
+}
+
+func (p *printer) andOr(ao *andOr) {
+	for i, pl := range ao.pipelines {
+		if i > 0 {
+			p.buf.WriteString(" " + ao.ops[i-1] + " ")
+		}
+		if pl.timePosix {
+			p.buf.WriteString("time -p ")
+		} else if pl.timed {
+			p.buf.WriteString("time ")
+		}
+		if pl.bang {
+			p.buf.WriteString("! ")
+		}
+		for j, c := range pl.cmds {
+			if j > 0 {
+				p.buf.WriteString(" | ")
+			}
+			p.command(c)
+		}
+	}
+}
+
+func (p *printer) command(c command) {
+	switch c := c.(type) {
+	case *simpleCommand:
+		p.words(c.assigns)
+		if len(c.assigns) == 0 && len(c.args) > 0 && isReserved(c.args[0].raw) {
+			p.redirects(c.redirects, false)
+			p.buf.WriteByte(' ')
+			p.words(c.args)
+			return
+		}
+		if len(c.assigns) > 0 && len(c.args) > 0 {
+			p.buf.WriteByte(' ')
+		}
+		p.words(c.args)
+		p.redirects(c.redirects, len(c.assigns)+len(c.args) > 0)
+	case *subshell:
+		p.buf.WriteString("( ")
+		p.list(c.body, false)
+		p.buf.WriteString(" )")
+		p.redirects(c.redirects, true)
+	case *braceGroup:
+		p.buf.WriteString("{ ")
+		p.list(c.body, true)
+		p.buf.WriteString(" }")
+		p.redirects(c.redirects, true)
+	case *ifClause:
+		for i, cond := range c.conds {
+			if i == 0 {
+				p.buf.WriteString("if ")
+			} else {
+				p.buf.WriteString(" elif ")
+			}
+			p.list(cond, true)
+			p.buf.WriteString(" then ")
+			p.list(c.bodies[i], true)
+		}
+		if c.elseBody != nil {
+			p.buf.WriteString(" else ")
+			p.list(c.elseBody, true)
+		}
+		p.buf.WriteString(" fi")
+		p.redirects(c.redirects, true)
+	case *loopClause:
+		if c.until {
+			p.buf.WriteString("until ")
+		} else {
+			p.buf.WriteString("while ")
+		}
+		p.list(c.cond, true)
+		p.buf.WriteString(" do ")
+		p.list(c.body, true)
+		p.buf.WriteString(" done")
+		p.redirects(c.redirects, true)
+	case *forClause:
+		p.buf.WriteString("for " + c.name)
+		if c.hasIn {
+			p.buf.WriteString(" in")
+			for _, w := range c.words {
+				p.buf.WriteString(" " + w.raw)
+			}
+		}
+		p.buf.WriteString("; do ")
+		p.list(c.body, true)
+		p.buf.WriteString(" done")
+		p.redirects(c.redirects, true)
+	case *caseClause:
+		p.buf.WriteString("case " + c.word.raw + " in ")
+		for _, item := range c.items {
+			if item.patterns[0].raw == "esac" {
+				p.buf.WriteByte('(')
+			}
+			for i, w := range item.patterns {
+				if i > 0 {
+					p.buf.WriteString(" | ")
+				}
+				p.buf.WriteString(w.raw)
+			}
+			p.buf.WriteString(") ")
+			p.list(item.body, false)
+			if len(item.body.items) > 0 {
+				p.buf.WriteByte(' ')
+			}
+			p.buf.WriteString(";; ")
+		}
+		p.buf.WriteString("esac")
+		p.redirects(c.redirects, true)
+	case *funcDef:
+		p.buf.WriteString(c.name + "() ")
+		p.command(c.body)
+	}
+}
+
+func (p *printer) words(words []word) {
+	for i, w := range words {
+		if i > 0 {
+			p.buf.WriteByte(' ')
+		}
+		p.buf.WriteString(w.raw)
+	}
+}
+
+func (p *printer) redirects(redirs []*redirect, space bool) {
+	for _, r := range redirs {
+		if space {
+			p.buf.WriteByte(' ')
+		}
+		space = true
+		if (r.op[0] == '<' && r.fd != 0) || (r.op[0] == '>' && r.fd != 1) {
+			fmt.Fprint(&p.buf, r.fd)
+		}
+		p.buf.WriteString(r.op)
+		p.buf.WriteString(r.target.raw)
+		if r.op == "<<" || r.op == "<<-" {
+			p.heredocs = append(p.heredocs, r)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package interp
+
+import (
+	"strings"
+	"testing"
+)
+
+func lexAll(src string) []token {
+	l := &lexer{src: src}
+	var tokens []token
+	for {
+		tok := l.next()
+		if tok.kind == tokEOF || l.err != nil {
+			return tokens
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestLexer(t *testing.T) {
+	line := `echo "hello world" && ps aux | grep "go run" || echo fail; (cd /tmp) &`
+	tokens := lexAll(line)
+	expected := []string{"echo", `"hello world"`, "&&", "ps", "aux", "|", "grep", `"go run"`, "||", "echo", "fail", ";", "(", "cd", "/tmp", ")", "&"}
+	if len(tokens) != len(expected) {
+		t.Fatalf("token count mismatch: expected %d, got %d", len(expected), len(tokens))
+	}
+	for i := range tokens {
+		if tokens[i].val != expected[i] {
+			t.Errorf("token %d: expected '%s', got '%s'", i, expected[i], tokens[i].val)
+		}
+	}
+}
+
+func TestLexerRedirects(t *testing.T) {
+	line := `cmd >>log 2>&1 <in 2>err &>all <<<"a b" x2>y`
+	tokens := lexAll(line)
+	expected := []struct {
+		val string
+		fd  int
+	}{
+		{"cmd", 0}, {">>", 1}, {"log", 0}, {">&", 2}, {"1", 0}, {"<", 0}, {"in", 0},
+		{">", 2}, {"err", 0}, {"&>", 1}, {"all", 0}, {"<<<", 0}, {`"a b"`, 0}, {"x2", 0}, {">", 1}, {"y", 0},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("token count mismatch: expected %d, got %d", len(expected), len(tokens))
+	}
+	for i := range tokens {
+		if tokens[i].val != expected[i].val || (tokens[i].kind == tokRedirect && tokens[i].fd != expected[i].fd) {
+			t.Errorf("token %d: expected %s (fd %d), got %s (fd %d)", i, expected[i].val, expected[i].fd, tokens[i].val, tokens[i].fd)
+		}
+	}
+}
+
+func TestLexerWords(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []string
+	}{
+		{`a\ b c`, []string{`a\ b`, "c"}},
+		{`"x | y" 'p;q'`, []string{`"x | y"`, `'p;q'`}},
+		{`$(echo a | tr a b)x y`, []string{`$(echo a | tr a b)x`, "y"}},
+		{`$((1 + (2 * 3))) ${A:-b c}`, []string{`$((1 + (2 * 3)))`, `${A:-b c}`}},
+		{"`echo ;` z # comment", []string{"`echo ;`", "z"}},
+		{"a\\\nb", []string{"a\\\nb"}},
+	}
+	for _, c := range cases {
+		tokens := lexAll(c.src)
+		var got []string
+		for _, tok := range tokens {
+			got = append(got, tok.val)
+		}
+		if strings.Join(got, "\x00") != strings.Join(c.want, "\x00") {
+			t.Errorf("lex(%q) = %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	prog, err := parse(`echo "hello world" && ls -l | grep main > out.txt; { pwd; } || (exit) &`)
+	if err != nil {
+		t.Fatal("parse error:", err)
+	}
+	if len(prog.items) != 2 {
+		t.Fatalf("expected 2 list items, got %d", len(prog.items))
+	}
+
+	first := prog.items[0]
+	if len(first.pipelines) != 2 || first.ops[0] != "&&" || first.background {
+		t.Fatalf("first and-or list parsed incorrectly: %+v", first)
+	}
+	echo := first.pipelines[0].cmds[0].(*simpleCommand)
+	if echo.args[0].raw != "echo" || unquote(echo.args[1].raw) != "hello world" {
+		t.Error("echo command parsing incorrect")
+	}
+	pipe := first.pipelines[1]
+	if len(pipe.cmds) != 2 {
+		t.Fatalf("expected 2 pipeline stages, got %d", len(pipe.cmds))
+	}
+	grep := pipe.cmds[1].(*simpleCommand)
+	if grep.args[0].raw != "grep" || len(grep.redirects) != 1 || grep.redirects[0].target.raw != "out.txt" {
+		t.Error("grep command parsing or redirect incorrect")
+	}
+
+	second := prog.items[1]
+	if !second.background || second.ops[0] != "||" {
+		t.Fatalf("second and-or list parsed incorrectly: %+v", second)
+	}
+	if _, ok := second.pipelines[0].cmds[0].(*braceGroup); !ok {
+		t.Error("expected brace group")
+	}
+	if _, ok := second.pipelines[1].cmds[0].(*subshell); !ok {
+		t.Error("expected subshell")
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	prog, err := parse(`cat <<< hello 2>> err.log >&2`)
+	if err != nil {
+		t.Fatal("parse error:", err)
+	}
+	c := prog.items[0].pipelines[0].cmds[0].(*simpleCommand)
+	want := []struct {
+		fd     int
+		op     string
+		target string
+	}{
+		{0, "<<<", "hello"},
+		{2, ">>", "err.log"},
+		{1, ">&", "2"},
+	}
+	if len(c.redirects) != len(want) {
+		t.Fatalf("expected %d redirects, got %d", len(want), len(c.redirects))
+	}
+	for i, r := range c.redirects {
+		if r.fd != want[i].fd || r.op != want[i].op || r.target.raw != want[i].target {
+			t.Errorf("redirect %d = %d%s%s, want %+v", i, r.fd, r.op, r.target.raw, want[i])
+		}
+	}
+}
+
+func TestParseHeredocs(t *testing.T) {
+	prog, err := parse("cat <<EOF | tr a b <<-'END'\none\n$two\nEOF\n\tthree\n\tEND\necho done")
+	if err != nil {
+		t.Fatal("parse error:", err)
+	}
+	if len(prog.items) != 2 {
+		t.Fatalf("expected 2 list items, got %d", len(prog.items))
+	}
+	cmds := prog.items[0].pipelines[0].cmds
+	first := cmds[0].(*simpleCommand).redirects[0]
+	if first.body != "one\n$two\n" || first.quoted {
+		t.Errorf("first heredoc = %q (quoted %v)", first.body, first.quoted)
+	}
+	second := cmds[1].(*simpleCommand).redirects[0]
+	if second.body != "three\n" || second.delim != "END" || !second.quoted {
+		t.Errorf("second heredoc = %q delim %q (quoted %v)", second.body, second.delim, second.quoted)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		src        string
+		want       string
+		incomplete bool
+	}{
+		{"echo a |", "1:9: unexpected end of input", true},
+		{"| wc", "1:1: unexpected '|'", false},
+		{"echo a;;", "1:7: unexpected ';;'", false},
+		{"echo a\nls && || b", "2:7: unexpected '||'", false},
+		{"echo 'abc", "1:6: unterminated single quote", true},
+		{`echo "$(ls`, "1:11: unexpected end of input", true},
+		{"(echo a", "1:8: unexpected end of input", true},
+		{"echo a )", "1:8: unexpected ')'", false},
+		{"{ echo a; } }", "1:13: unexpected '}'", false},
+		{"{ }", "1:3: unexpected '}'", false},
+		{"echo >", "1:7: expected word after '>', found end of input", false},
+		{"cat <<EOF\nabc", "1:5: here-document delimited by end-of-file (wanted 'EOF')", true},
+		{"if true; then", "1:14: unexpected end of input", true},
+		{"if true; fi", "1:10: unexpected 'fi'", false},
+		{"while a; do done", "1:13: unexpected 'done'", false},
+		{"for 1 in a; do b; done", "1:5: expected variable name after 'for', found '1'", false},
+		{"case x in a) b;; c", "1:19: unexpected end of input", true},
+		{"f() echo", "1:5: function body must be a compound command", false},
+		{"echo; then", "1:7: unexpected 'then'", false},
+		{"! time ls", "1:3: unexpected 'time'", false},
+		{"time", "1:5: unexpected end of input", true},
+	}
+	for _, c := range cases {
+		_, err := parse(c.src)
+		if err == nil {
+			t.Errorf("parse(%q): expected error", c.src)
+			continue
+		}
+		if err.Error() != c.want || isIncomplete(err) != c.incomplete {
+			t.Errorf("parse(%q) error = %q (incomplete %v), want %q (incomplete %v)", c.src, err, isIncomplete(err), c.want, c.incomplete)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"echo  a;ls|wc -l&&true", "echo a; ls | wc -l && true"},
+		{"{ a & } 2>/dev/null\n(b;c)>out", "{ a & } 2>/dev/null; ( b; c ) >out"},
+		{"! cat <<E 3<in\nx\nE\necho", "! cat <<E 3<in\nx\nE\necho"},
+		{">x {", ">x {"},
+		{"if a\nthen b\nelif c; then d; else e\nfi >out", "if a; then b; elif c; then d; else e; fi >out"},
+		{"while a; do b & done; until a\ndo b; done", "while a; do b & done; until a; do b; done"},
+		{"for x in 1 2\ndo echo $x; done; for y do :; done", "for x in 1 2; do echo $x; done; for y; do :; done"},
+		{"case $x in\n(a|b) echo ab;;\n*) ;;\nesac", "case $x in a | b) echo ab ;; *) ;; esac"},
+		{"case x in (esac) y; esac", "case x in (esac) y ;; esac"},
+		{"f ()\n{ echo $1; }", "f() { echo $1; }"},
+		{"time  a|b && time -p ! c; >x time", "time a | b && time -p ! c; >x time"},
+	}
+	for _, c := range cases {
+		prog, err := parse(c.src)
+		if err != nil {
+			t.Fatalf("parse(%q): %v", c.src, err)
+		}
+		if got := prog.String(); got != c.want {
+			t.Errorf("print(%q) = %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		`echo "hello world" && ls -l | grep main > out.txt`,
+		"a; b & c || ! d | e",
+		"(cd /tmp && ls) | { read x; echo $x; } 2>&1",
+		"cat <<EOF <<-'X'\nbody $y\nEOF\n\tz\n\tX\n",
+		"echo $(ls | wc) `date` $((1+2)) ${a:-b}",
+		"echo a\\\nb 'q;' \"x\\\"y\" # done",
+		"x 2>>err <&0 &>all >|f <>g",
+		"if a; then b; elif c; then d; else e; fi",
+		"while a; do b; done; until a; do b; done",
+		"for x in a b; do case $x in a|b) echo;; *) ;; esac; done",
+		"f() { return 1; }",
+		"time -p ! a | b",
+		"",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		prog, err := parse(src)
+		if err != nil {
+			se, ok := err.(*syntaxError)
+			if !ok {
+				t.Fatalf("parse(%q) returned non-syntax error %T", src, err)
+			}
+			if se.line < 1 || se.col < 1 {
+				t.Fatalf("parse(%q) returned bad position %d:%d", src, se.line, se.col)
+			}
+			return
+		}
+		printed := prog.String()
+		again, err := parse(printed)
+		if err != nil {
+			t.Fatalf("reparse of %q (from %q) failed: %v", printed, src, err)
+		}
+		if again.String() != printed {
+			t.Fatalf("print is not stable: %q -> %q", printed, again.String())
+		}
+	})
+}
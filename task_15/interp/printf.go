@@ -0,0 +1,242 @@
+package interp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// printfState holds the arguments left to a printf format and whether
+// output has been cut short by \c.
+type printfState struct {
+	args   []string
+	used   bool
+	status int
+	stop   bool
+}
+
+func (p *printfState) next() string {
+	if len(p.args) == 0 {
+		return ""
+	}
+	p.used = true
+	arg := p.args[0]
+	p.args = p.args[1:]
+	return arg
+}
+
+// number converts a printf argument to an integer. A leading quote gives
+// the code of the following character.
+func (p *printfState) number(arg string, std stdio) int64 {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		if len(arg) == 1 {
+			return 0
+		}
+		return int64([]rune(arg[1:])[0])
+	}
+	n, err := strconv.ParseInt(arg, 0, 64)
+	if err != nil {
+		fmt.Fprintf(std.err, "printf: %s: invalid number\n", arg)
+		p.status = 1
+	}
+	return n
+}
+
+func (p *printfState) float(arg string, std stdio) float64 {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		return float64(p.number(arg, std))
+	}
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		fmt.Fprintf(std.err, "printf: %s: invalid number\n", arg)
+		p.status = 1
+	}
+	return f
+}
+
+// builtinPrintf formats its arguments under control of the format, which
+// is reused until the arguments are used up. It supports the conversions
+// %s %b %q %c %d %i %u %o %x %X %e %E %f %F %g %G and %%, with flags,
+// width and precision, and backslash escapes in the format.
+func builtinPrintf(sh *shell, args []string, std stdio) int {
+	if len(args) < 2 {
+		fmt.Fprintln(std.err, "printf: usage: printf format [arguments]")
+		return 2
+	}
+	format := args[1]
+	p := &printfState{args: args[2:]}
+	var out strings.Builder
+	for {
+		p.used = false
+		if !p.format(&out, format, std) || p.stop || !p.used || len(p.args) == 0 {
+			break
+		}
+	}
+	fmt.Fprint(std.out, out.String())
+	return p.status
+}
+
+// format writes one pass of the format to out. It reports false if the
+// format is invalid.
+func (p *printfState) format(out *strings.Builder, format string, std stdio) bool {
+	for i := 0; i < len(format); i++ {
+		switch format[i] {
+		case '\\':
+			text, n, _ := printfEscape(format, i, false)
+			out.WriteString(text)
+			i += n - 1
+			continue
+		case '%':
+		default:
+			out.WriteByte(format[i])
+			continue
+		}
+
+		if i+1 < len(format) && format[i+1] == '%' {
+			out.WriteByte('%')
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			j++
+		}
+		spec := format[i:j]
+		for _, part := range []string{"width", "precision"} {
+			if part == "precision" {
+				if j >= len(format) || format[j] != '.' {
+					break
+				}
+				spec += "."
+				j++
+			}
+			if j < len(format) && format[j] == '*' {
+				spec += strconv.FormatInt(p.number(p.next(), std), 10)
+				j++
+				continue
+			}
+			start := j
+			for j < len(format) && '0' <= format[j] && format[j] <= '9' {
+				j++
+			}
+			spec += format[start:j]
+		}
+		if j >= len(format) {
+			fmt.Fprintf(std.err, "printf: %s: missing format character\n", format[i:])
+			p.status = 1
+			return false
+		}
+
+		switch conv := format[j]; conv {
+		case 's':
+			fmt.Fprintf(out, spec+"s", p.next())
+		case 'b':
+			arg := p.next()
+			var b strings.Builder
+			for k := 0; k < len(arg); k++ {
+				if arg[k] != '\\' {
+					b.WriteByte(arg[k])
+					continue
+				}
+				text, n, stop := printfEscape(arg, k, true)
+				if stop {
+					p.stop = true
+					break
+				}
+				b.WriteString(text)
+				k += n - 1
+			}
+			fmt.Fprintf(out, spec+"s", b.String())
+			if p.stop {
+				return true
+			}
+		case 'q':
+			fmt.Fprintf(out, spec+"s", shellQuote(p.next()))
+		case 'c':
+			arg := p.next()
+			if arg != "" {
+				arg = string([]rune(arg)[:1])
+			}
+			fmt.Fprintf(out, spec+"s", arg)
+		case 'd', 'i':
+			fmt.Fprintf(out, spec+"d", p.number(p.next(), std))
+		case 'u', 'o', 'x', 'X':
+			verb := conv
+			if conv == 'u' {
+				verb = 'd'
+			}
+			fmt.Fprintf(out, spec+string(verb), uint64(p.number(p.next(), std)))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			if conv == 'F' {
+				conv = 'f'
+			}
+			fmt.Fprintf(out, spec+string(conv), p.float(p.next(), std))
+		default:
+			fmt.Fprintf(std.err, "printf: %%%c: invalid format character\n", conv)
+			p.status = 1
+			return false
+		}
+		i = j
+	}
+	return true
+}
+
+// printfEscape decodes the backslash escape at s[i] and returns its text
+// and length. In %b arguments (inArg) octal escapes take the form \0nnn
+// and \c ends the output, reported by stop.
+func printfEscape(s string, i int, inArg bool) (text string, n int, stop bool) {
+	if i+1 >= len(s) {
+		return "\\", 1, false
+	}
+	switch ch := s[i+1]; ch {
+	case 'a':
+		return "\a", 2, false
+	case 'b':
+		return "\b", 2, false
+	case 'f':
+		return "\f", 2, false
+	case 'n':
+		return "\n", 2, false
+	case 'r':
+		return "\r", 2, false
+	case 't':
+		return "\t", 2, false
+	case 'v':
+		return "\v", 2, false
+	case '\\', '"', '\'':
+		return string(ch), 2, false
+	case 'c':
+		if inArg {
+			return "", 2, true
+		}
+	case 'x':
+		j := i + 2
+		for j < len(s) && j < i+4 && strings.IndexByte("0123456789abcdefABCDEF", s[j]) >= 0 {
+			j++
+		}
+		if j > i+2 {
+			v, _ := strconv.ParseUint(s[i+2:j], 16, 8)
+			return string([]byte{byte(v)}), j - i, false
+		}
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		start := i + 1
+		if inArg && ch == '0' {
+			start++
+		}
+		j := start
+		for j < len(s) && j < start+3 && '0' <= s[j] && s[j] <= '7' {
+			j++
+		}
+		v, _ := strconv.ParseUint("0"+s[start:j], 8, 16)
+		return string([]byte{byte(v)}), j - i, false
+	}
+	return s[i : i+2], 2, false
+}
@@ -0,0 +1,500 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/<pid>/stat.
+const clockTicks = 100
+
+type procInfo struct {
+	pid     int
+	ppid    int
+	pgid    int
+	sid     int
+	tpgid   int
+	state   byte
+	nice    int
+	threads int
+	ticks   uint64
+	rss     int64
+	uid     int
+	comm    string
+	cmd     string
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat. The command name
+// is in parentheses and may itself contain spaces and parentheses, so the
+// remaining fields are found after the last ')'.
+func parseProcStat(data string) (procInfo, error) {
+	var p procInfo
+	open := strings.IndexByte(data, '(')
+	end := strings.LastIndexByte(data, ')')
+	if open < 0 || end < open {
+		return p, fmt.Errorf("malformed stat line")
+	}
+	p.comm = data[open+1 : end]
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return p, fmt.Errorf("malformed stat line")
+	}
+
+	var err error
+	num := func(i int) int64 {
+		n, e := strconv.ParseInt(fields[i], 10, 64)
+		if e != nil && err == nil {
+			err = e
+		}
+		return n
+	}
+	if p.pid, err = strconv.Atoi(strings.TrimSpace(data[:open])); err != nil {
+		return p, err
+	}
+	p.state = fields[0][0]
+	p.ppid = int(num(1))
+	p.pgid = int(num(2))
+	p.sid = int(num(3))
+	p.tpgid = int(num(5))
+	p.ticks = uint64(num(11) + num(12))
+	p.nice = int(num(16))
+	p.threads = int(num(17))
+	p.rss = num(21) * int64(os.Getpagesize()) / 1024
+	return p, err
+}
+
+func readProc(pid int) (procInfo, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	data, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return procInfo{}, err
+	}
+	p, err := parseProcStat(string(data))
+	if err != nil {
+		return p, fmt.Errorf("%s/stat: %w", dir, err)
+	}
+
+	p.uid = -1
+	if status, err := os.ReadFile(dir + "/status"); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if rest, ok := strings.CutPrefix(line, "Uid:"); ok {
+				if f := strings.Fields(rest); len(f) > 0 {
+					p.uid, _ = strconv.Atoi(f[0])
+				}
+			}
+		}
+	}
+	if cmdline, err := os.ReadFile(dir + "/cmdline"); err == nil {
+		p.cmd = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	if p.cmd == "" {
+		p.cmd = "[" + p.comm + "]"
+	}
+	return p, nil
+}
+
+func listProcs() ([]procInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []procInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if p, err := readProc(pid); err == nil {
+			procs = append(procs, p)
+		}
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+// stat formats the process state like ps STAT: the state letter followed
+// by < or N for priority, s for a session leader, l for multi-threaded
+// and + for the foreground process group.
+func (p procInfo) stat() string {
+	s := string(p.state)
+	switch {
+	case p.nice < 0:
+		s += "<"
+	case p.nice > 0:
+		s += "N"
+	}
+	if p.pid == p.sid {
+		s += "s"
+	}
+	if p.threads > 1 {
+		s += "l"
+	}
+	if p.tpgid > 0 && p.pgid == p.tpgid {
+		s += "+"
+	}
+	return s
+}
+
+func formatCPUTime(ticks uint64) string {
+	secs := ticks / clockTicks
+	h, m, s := secs/3600, secs/60%60, secs%60
+	if h >= 24 {
+		return fmt.Sprintf("%d-%02d:%02d:%02d", h/24, h%24, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+}
+
+type psColumn struct {
+	header string
+	width  int
+	value  func(p procInfo) string
+}
+
+var psColumns = map[string]psColumn{
+	"pid":  {"PID", 7, func(p procInfo) string { return strconv.Itoa(p.pid) }},
+	"ppid": {"PPID", 7, func(p procInfo) string { return strconv.Itoa(p.ppid) }},
+	"pgid": {"PGID", 7, func(p procInfo) string { return strconv.Itoa(p.pgid) }},
+	"sid":  {"SID", 7, func(p procInfo) string { return strconv.Itoa(p.sid) }},
+	"stat": {"STAT", -4, procInfo.stat},
+	"time": {"TIME", 8, func(p procInfo) string { return formatCPUTime(p.ticks) }},
+	"rss":  {"RSS", 8, func(p procInfo) string { return strconv.FormatInt(p.rss, 10) }},
+	"uid":  {"UID", 5, func(p procInfo) string { return strconv.Itoa(p.uid) }},
+	"user": {"USER", -8, procUser},
+	"comm": {"COMMAND", -15, func(p procInfo) string { return p.comm }},
+	"cmd":  {"CMD", 0, func(p procInfo) string { return p.cmd }},
+	"args": {"COMMAND", 0, func(p procInfo) string { return p.cmd }},
+}
+
+func procUser(p procInfo) string {
+	name := strconv.Itoa(p.uid)
+	if u, err := user.LookupId(name); err == nil {
+		return u.Username
+	}
+	return name
+}
+
+const psUsage = "usage: ps [-e] [-f] [-p pid[,pid...]] [-o col[,col...]] [--no-headers]"
+
+// builtinPs lists processes from /proc. Without -p it shows every process;
+// -o selects the columns from psColumns and -f is shorthand for a full
+// listing.
+func builtinPs(sh *shell, args []string, std stdio) int {
+	cols := []string{"pid", "ppid", "stat", "time", "cmd"}
+	var pids map[int]bool
+	headers := true
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-e", "-A", "aux", "ax":
+		case "-f":
+			cols = []string{"user", "pid", "ppid", "stat", "time", "cmd"}
+		case "--no-headers":
+			headers = false
+		case "-o", "-p":
+			if i+1 >= len(args) {
+				fmt.Fprintf(std.err, "ps: option %s requires an argument\n%s\n", arg, psUsage)
+				return 1
+			}
+			i++
+			list := strings.FieldsFunc(args[i], func(r rune) bool { return r == ',' || r == ' ' })
+			if arg == "-o" {
+				cols = nil
+				for _, name := range list {
+					if _, ok := psColumns[name]; !ok {
+						fmt.Fprintf(std.err, "ps: unknown column %q\n%s\n", name, psUsage)
+						return 1
+					}
+					cols = append(cols, name)
+				}
+				continue
+			}
+			if pids == nil {
+				pids = make(map[int]bool)
+			}
+			for _, s := range list {
+				pid, err := strconv.Atoi(s)
+				if err != nil {
+					fmt.Fprintf(std.err, "ps: invalid process id %q\n", s)
+					return 1
+				}
+				pids[pid] = true
+			}
+		default:
+			fmt.Fprintf(std.err, "ps: unknown option %s\n%s\n", arg, psUsage)
+			return 1
+		}
+	}
+
+	procs, err := listProcs()
+	if err != nil {
+		fmt.Fprintln(std.err, "ps:", err)
+		return 1
+	}
+
+	row := func(value func(c psColumn) string) string {
+		cells := make([]string, len(cols))
+		for i, name := range cols {
+			c := psColumns[name]
+			if i == len(cols)-1 && c.width < 0 {
+				cells[i] = value(c)
+			} else {
+				cells[i] = fmt.Sprintf("%*s", c.width, value(c))
+			}
+		}
+		return strings.Join(cells, " ")
+	}
+	if headers {
+		fmt.Fprintln(std.out, row(func(c psColumn) string { return c.header }))
+	}
+	found := false
+	for _, p := range procs {
+		if pids != nil && !pids[p.pid] {
+			continue
+		}
+		found = true
+		fmt.Fprintln(std.out, row(func(c psColumn) string { return c.value(p) }))
+	}
+	if pids != nil && !found {
+		return 1
+	}
+	return 0
+}
+
+var signals = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"HUP", syscall.SIGHUP}, {"INT", syscall.SIGINT}, {"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL}, {"TRAP", syscall.SIGTRAP}, {"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS}, {"FPE", syscall.SIGFPE}, {"KILL", syscall.SIGKILL},
+	{"USR1", syscall.SIGUSR1}, {"SEGV", syscall.SIGSEGV}, {"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE}, {"ALRM", syscall.SIGALRM}, {"TERM", syscall.SIGTERM},
+	{"STKFLT", syscall.SIGSTKFLT}, {"CHLD", syscall.SIGCHLD}, {"CONT", syscall.SIGCONT},
+	{"STOP", syscall.SIGSTOP}, {"TSTP", syscall.SIGTSTP}, {"TTIN", syscall.SIGTTIN},
+	{"TTOU", syscall.SIGTTOU}, {"URG", syscall.SIGURG}, {"XCPU", syscall.SIGXCPU},
+	{"XFSZ", syscall.SIGXFSZ}, {"VTALRM", syscall.SIGVTALRM}, {"PROF", syscall.SIGPROF},
+	{"WINCH", syscall.SIGWINCH}, {"IO", syscall.SIGIO}, {"PWR", syscall.SIGPWR},
+	{"SYS", syscall.SIGSYS},
+}
+
+// parseSignal accepts a signal number or a name with or without the SIG
+// prefix, in any case.
+func parseSignal(s string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n == 0 {
+			return 0, true
+		}
+		for _, sig := range signals {
+			if int(sig.sig) == n {
+				return sig.sig, true
+			}
+		}
+		return 0, false
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	for _, sig := range signals {
+		if sig.name == name {
+			return sig.sig, true
+		}
+	}
+	return 0, false
+}
+
+func signalName(sig syscall.Signal) string {
+	for _, s := range signals {
+		if s.sig == sig {
+			return s.name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// findJob resolves a job spec: %n, %% or %+ for the most recent job, or
+// %prefix for the job whose command starts with prefix.
+func (sh *shell) findJob(spec string) (*job, error) {
+	s := strings.TrimPrefix(spec, "%")
+	if len(sh.jobs) == 0 {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	if s == "" || s == "%" || s == "+" {
+		return sh.jobs[len(sh.jobs)-1], nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		for _, j := range sh.jobs {
+			if j.id == n {
+				return j, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	var found *job
+	for _, j := range sh.jobs {
+		if strings.HasPrefix(j.text, s) {
+			if found != nil {
+				return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+			}
+			found = j
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// builtinWait waits for the background jobs given as job specs or process
+// IDs, or for all of them, and returns the status of the last one given,
+// or 0.
+func builtinWait(sh *shell, args []string, std stdio) int {
+	if len(args) == 1 {
+		for len(sh.jobs) > 0 && sh.ctx.Err() == nil {
+			sh.waitJob(sh.jobs[0])
+		}
+		return 0
+	}
+	status := 0
+	for _, target := range args[1:] {
+		var j *job
+		if strings.HasPrefix(target, "%") {
+			var err error
+			if j, err = sh.findJob(target); err != nil {
+				fmt.Fprintln(std.err, "wait:", err)
+				status = 127
+				continue
+			}
+		} else {
+			pid, err := strconv.Atoi(target)
+			if err != nil {
+				fmt.Fprintf(std.err, "wait: %s: not a pid or valid job spec\n", target)
+				status = 2
+				continue
+			}
+			if j = sh.jobByPid(pid); j == nil {
+				fmt.Fprintf(std.err, "wait: pid %d is not a child of this shell\n", pid)
+				status = 127
+				continue
+			}
+		}
+		status = sh.waitJob(j)
+	}
+	return status
+}
+
+// waitJob waits for j to finish, or for the shell's context to be
+// cancelled, drops it from the job table and returns its status.
+func (sh *shell) waitJob(j *job) int {
+	select {
+	case <-j.done:
+	case <-sh.ctx.Done():
+		return 128 + int(syscall.SIGINT)
+	}
+	for i, other := range sh.jobs {
+		if other == j {
+			sh.jobs = append(sh.jobs[:i:i], sh.jobs[i+1:]...)
+			break
+		}
+	}
+	return j.status
+}
+
+func (sh *shell) jobByPid(pid int) *job {
+	for _, j := range sh.jobs {
+		j.mu.Lock()
+		found := slices.Contains(j.pids, pid)
+		j.mu.Unlock()
+		if found {
+			return j
+		}
+	}
+	return nil
+}
+
+func builtinKill(sh *shell, args []string, std stdio) int {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "-l" {
+		if len(args) == 1 {
+			names := make([]string, len(signals))
+			for i, s := range signals {
+				names[i] = s.name
+			}
+			fmt.Fprintln(std.out, strings.Join(names, " "))
+			return 0
+		}
+		status := 0
+		for _, arg := range args[1:] {
+			if n, err := strconv.Atoi(arg); err == nil && n > 128 {
+				arg = strconv.Itoa(n - 128)
+			}
+			sig, ok := parseSignal(arg)
+			switch {
+			case !ok:
+				fmt.Fprintf(std.err, "kill: %s: invalid signal specification\n", arg)
+				status = 1
+			case isDigits(arg):
+				fmt.Fprintln(std.out, signalName(sig))
+			default:
+				fmt.Fprintln(std.out, int(sig))
+			}
+		}
+		return status
+	}
+
+	sig := syscall.SIGTERM
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "--" {
+		spec := args[0][1:]
+		args = args[1:]
+		if spec == "s" || spec == "n" {
+			if len(args) == 0 {
+				fmt.Fprintf(std.err, "kill: -%s: option requires an argument\n", spec)
+				return 2
+			}
+			spec, args = args[0], args[1:]
+		}
+		var ok bool
+		if sig, ok = parseSignal(spec); !ok {
+			fmt.Fprintf(std.err, "kill: %s: invalid signal specification\n", spec)
+			return 1
+		}
+	} else if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(std.err, "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | %job ... or kill -l [sigspec]")
+		return 2
+	}
+
+	status := 0
+	for _, target := range args {
+		var pid int
+		if strings.HasPrefix(target, "%") {
+			j, err := sh.findJob(target)
+			if err != nil {
+				fmt.Fprintln(std.err, "kill:", err)
+				status = 1
+				continue
+			}
+			if err := j.signal(sig); err != nil {
+				fmt.Fprintf(std.err, "kill: %s: %v\n", target, err)
+				status = 1
+			}
+			continue
+		} else {
+			var err error
+			if pid, err = strconv.Atoi(target); err != nil {
+				fmt.Fprintf(std.err, "kill: %s: arguments must be process or job IDs\n", target)
+				status = 1
+				continue
+			}
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			fmt.Fprintf(std.err, "kill: (%s) - %v\n", target, err)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,136 @@
+package interp
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	line := "4242 (my (odd) prog) S 1 4242 4242 34816 4242 4194560 100 0 0 0 250 130 0 0 20 5 3 0 123 4096 300 18446744073709551615"
+	p, err := parseProcStat(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.pid != 4242 || p.comm != "my (odd) prog" || p.ppid != 1 || p.pgid != 4242 || p.sid != 4242 || p.ticks != 380 || p.threads != 3 {
+		t.Errorf("parsed %+v", p)
+	}
+	if got := p.stat(); got != "SNsl+" {
+		t.Errorf("stat() = %q, want SNsl+", got)
+	}
+	if got := formatCPUTime(p.ticks); got != "00:00:03" {
+		t.Errorf("formatCPUTime = %q", got)
+	}
+	if got := formatCPUTime(clockTicks * (2*86400 + 3661)); got != "2-01:01:01" {
+		t.Errorf("formatCPUTime = %q", got)
+	}
+	if _, err := parseProcStat("garbage"); err == nil {
+		t.Error("expected error for malformed line")
+	}
+}
+
+func TestPsOptions(t *testing.T) {
+	sh := newShell()
+	pid := strconv.Itoa(os.Getpid())
+
+	var out bytes.Buffer
+	if status := sh.runBuiltin([]string{"ps", "-o", "pid,ppid,comm", "-p", pid}, stdio{out: &out, err: &out}); status != 0 {
+		t.Fatalf("ps failed: %s", out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || strings.Fields(lines[0])[0] != "PID" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	fields := strings.Fields(lines[1])
+	if fields[0] != pid || fields[1] != strconv.Itoa(os.Getppid()) {
+		t.Errorf("row %q, want pid %s", lines[1], pid)
+	}
+
+	out.Reset()
+	sh.runBuiltin([]string{"ps", "--no-headers", "-p", pid}, stdio{out: &out, err: &out})
+	if fields := strings.Fields(out.String()); len(fields) < 5 || fields[0] != pid || !strings.Contains(out.String(), os.Args[0]) {
+		t.Errorf("default columns: %q", out.String())
+	}
+
+	for _, args := range [][]string{{"ps", "-o", "bogus"}, {"ps", "-p"}, {"ps", "-x"}, {"ps", "-p", "0"}} {
+		out.Reset()
+		if status := sh.runBuiltin(args, stdio{out: &out, err: &out}); status == 0 {
+			t.Errorf("%q: expected failure, output %q", args, out.String())
+		}
+	}
+}
+
+func TestKillSignals(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		want string
+	}{{"9", "KILL"}, {"SIGKILL", "KILL"}, {"kill", "KILL"}, {"HUP", "HUP"}, {"0", "0"}} {
+		sig, ok := parseSignal(c.spec)
+		if !ok || signalName(sig) != c.want {
+			t.Errorf("parseSignal(%q) = %v, %v", c.spec, sig, ok)
+		}
+	}
+	if _, ok := parseSignal("NOPE"); ok {
+		t.Error("parseSignal accepted an unknown name")
+	}
+
+	sh := newShell()
+	var out bytes.Buffer
+	sh.runBuiltin([]string{"kill", "-l", "15", "KILL", "130"}, stdio{out: &out, err: &out})
+	if out.String() != "TERM\n9\nINT\n" {
+		t.Errorf("kill -l output %q", out.String())
+	}
+
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not found")
+	}
+	var cmds []*exec.Cmd
+	args := []string{"kill", "-SIGKILL"}
+	for range 2 {
+		cmd := exec.Command("sleep", "10")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+		args = append(args, strconv.Itoa(cmd.Process.Pid))
+	}
+	out.Reset()
+	if status := sh.runBuiltin(append(args, "nonsense"), stdio{out: &out, err: &out}); status != 1 || !strings.Contains(out.String(), "nonsense: arguments must be process or job IDs") {
+		t.Errorf("kill status %d, output %q", status, out.String())
+	}
+	for _, cmd := range cmds {
+		if status := waitCommand(cmd); status != 128+9 {
+			t.Errorf("process exited with %d, want %d", status, 128+9)
+		}
+	}
+}
+
+func TestKillJob(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not found")
+	}
+	sh := newShell()
+	var out bytes.Buffer
+	std := stdio{in: strings.NewReader(""), out: &out, err: &out}
+	if status := runScriptTo(t, sh, "sleep 10 | sleep 10 &", &out); status != 0 {
+		t.Fatalf("status %d", status)
+	}
+	if status := sh.runBuiltin([]string{"kill", "-9", "%1"}, std); status != 0 {
+		t.Fatalf("kill %%1: status %d, output %q", status, out.String())
+	}
+	select {
+	case <-sh.jobs[0].done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not killed")
+	}
+	if sh.jobs[0].status != 128+9 {
+		t.Errorf("job status %d", sh.jobs[0].status)
+	}
+	if status := sh.runBuiltin([]string{"kill", "%2"}, std); status != 1 || !strings.Contains(out.String(), "%2: no such job") {
+		t.Errorf("kill %%2: status %d, output %q", status, out.String())
+	}
+}
@@ -0,0 +1,222 @@
+package interp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var testUnaryOps = "-e -f -d -r -w -x -s -L -h -p -S -b -c -n -z"
+
+var testBinaryOps = "= == != < > -eq -ne -lt -le -gt -ge"
+
+type testParser struct {
+	sh   *shell
+	args []string
+	pos  int
+}
+
+func builtinTest(sh *shell, args []string, std stdio) int {
+	name := args[0]
+	args = args[1:]
+	if name == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			fmt.Fprintln(std.err, "[: missing ']'")
+			return 2
+		}
+		args = args[:len(args)-1]
+	}
+
+	t := &testParser{sh: sh, args: args}
+	ok, err := t.eval()
+	if err == nil && t.pos < len(t.args) {
+		err = fmt.Errorf("%s: unexpected argument", t.args[t.pos])
+	}
+	if err != nil {
+		fmt.Fprintf(std.err, "%s: %v\n", name, err)
+		return 2
+	}
+	if ok {
+		return 0
+	}
+	return 1
+}
+
+func isTestOp(ops, s string) bool {
+	for _, op := range strings.Fields(ops) {
+		if op == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *testParser) peek(n int) (string, bool) {
+	if t.pos+n < len(t.args) {
+		return t.args[t.pos+n], true
+	}
+	return "", false
+}
+
+func (t *testParser) eval() (bool, error) {
+	if len(t.args) == 0 {
+		return false, nil
+	}
+	return t.or()
+}
+
+func (t *testParser) or() (bool, error) {
+	ok, err := t.and()
+	for err == nil {
+		if arg, _ := t.peek(0); arg != "-o" {
+			break
+		}
+		t.pos++
+		var rhs bool
+		rhs, err = t.and()
+		ok = ok || rhs
+	}
+	return ok, err
+}
+
+func (t *testParser) and() (bool, error) {
+	ok, err := t.not()
+	for err == nil {
+		if arg, _ := t.peek(0); arg != "-a" {
+			break
+		}
+		t.pos++
+		var rhs bool
+		rhs, err = t.not()
+		ok = ok && rhs
+	}
+	return ok, err
+}
+
+func (t *testParser) not() (bool, error) {
+	if arg, _ := t.peek(0); arg == "!" {
+		if _, more := t.peek(1); more {
+			t.pos++
+			ok, err := t.not()
+			return !ok, err
+		}
+	}
+	return t.primary()
+}
+
+func (t *testParser) primary() (bool, error) {
+	arg, ok := t.peek(0)
+	if !ok {
+		return false, fmt.Errorf("argument expected")
+	}
+
+	if op, ok := t.peek(1); ok && isTestOp(testBinaryOps, op) {
+		if rhs, ok := t.peek(2); ok {
+			t.pos += 3
+			return testBinary(arg, op, rhs)
+		}
+	}
+	if arg == "(" {
+		if _, more := t.peek(1); more {
+			t.pos++
+			ok, err := t.or()
+			if err != nil {
+				return false, err
+			}
+			if closing, _ := t.peek(0); closing != ")" {
+				return false, fmt.Errorf("')' expected")
+			}
+			t.pos++
+			return ok, nil
+		}
+	}
+	if isTestOp(testUnaryOps, arg) {
+		if operand, ok := t.peek(1); ok {
+			t.pos += 2
+			return t.unary(arg, operand), nil
+		}
+	}
+	t.pos++
+	return arg != "", nil
+}
+
+func (t *testParser) unary(op, operand string) bool {
+	switch op {
+	case "-n":
+		return operand != ""
+	case "-z":
+		return operand == ""
+	}
+
+	path := t.sh.path(operand)
+	switch op {
+	case "-r":
+		return syscall.Access(path, 4) == nil
+	case "-w":
+		return syscall.Access(path, 2) == nil
+	case "-x":
+		return syscall.Access(path, 1) == nil
+	case "-L", "-h":
+		info, err := os.Lstat(path)
+		return err == nil && info.Mode()&os.ModeSymlink != 0
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	switch op {
+	case "-f":
+		return info.Mode().IsRegular()
+	case "-d":
+		return info.IsDir()
+	case "-s":
+		return info.Size() > 0
+	case "-p":
+		return info.Mode()&os.ModeNamedPipe != 0
+	case "-S":
+		return info.Mode()&os.ModeSocket != 0
+	case "-b":
+		return info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+	case "-c":
+		return info.Mode()&os.ModeCharDevice != 0
+	}
+	return true
+}
+
+func testBinary(lhs, op, rhs string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return lhs == rhs, nil
+	case "!=":
+		return lhs != rhs, nil
+	case "<":
+		return lhs < rhs, nil
+	case ">":
+		return lhs > rhs, nil
+	}
+
+	a, err := strconv.ParseInt(strings.TrimSpace(lhs), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", lhs)
+	}
+	b, err := strconv.ParseInt(strings.TrimSpace(rhs), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", rhs)
+	}
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	}
+	return a >= b, nil
+}
@@ -0,0 +1,292 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type variable struct {
+	value    string
+	set      bool
+	exported bool
+}
+
+var shellOptions = []struct {
+	name string
+	flag byte
+}{
+	{"errexit", 'e'},
+	{"noglob", 'f'},
+	{"nounset", 'u'},
+	{"pipefail", 0},
+	{"xtrace", 'x'},
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
+}
+
+func isNameChar(ch byte) bool {
+	return isNameStart(ch) || ('0' <= ch && ch <= '9')
+}
+
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAssignment(raw string) bool {
+	eq := strings.IndexByte(raw, '=')
+	return eq > 0 && isName(raw[:eq])
+}
+
+func environVars(environ []string) map[string]variable {
+	vars := make(map[string]variable, len(environ))
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && isName(name) {
+			vars[name] = variable{value: value, set: true, exported: true}
+		}
+	}
+	return vars
+}
+
+func (sh *shell) getVar(name string) (string, bool) {
+	v, ok := sh.vars[name]
+	if !ok || !v.set {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (sh *shell) setVar(name, value string) {
+	v := sh.vars[name]
+	v.value = value
+	v.set = true
+	sh.vars[name] = v
+}
+
+func (sh *shell) exportVar(name string) {
+	v := sh.vars[name]
+	v.exported = true
+	sh.vars[name] = v
+}
+
+func (sh *shell) unsetVar(name string) {
+	delete(sh.vars, name)
+}
+
+// environ returns the environment for a child process: the exported
+// variables, overridden by the NAME=value pairs in extra.
+func (sh *shell) environ(extra []string) []string {
+	env := make(map[string]string)
+	for name, v := range sh.vars {
+		if v.set && v.exported {
+			env[name] = v.value
+		}
+	}
+	for _, kv := range extra {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	list := make([]string, 0, len(env))
+	for name, value := range env {
+		list = append(list, name+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// tempAssign applies per-command NAME=value assignments to the shell and
+// returns a function that restores the previous values.
+func (sh *shell) tempAssign(assigns []string) func() {
+	saved := make(map[string]*variable, len(assigns))
+	for _, kv := range assigns {
+		name, value, _ := strings.Cut(kv, "=")
+		if _, done := saved[name]; !done {
+			if v, ok := sh.vars[name]; ok {
+				saved[name] = &v
+			} else {
+				saved[name] = nil
+			}
+		}
+		sh.setVar(name, value)
+		sh.exportVar(name)
+	}
+	return func() {
+		for name, v := range saved {
+			if v == nil {
+				delete(sh.vars, name)
+			} else {
+				sh.vars[name] = *v
+			}
+		}
+	}
+}
+
+func (sh *shell) sortedVarNames() []string {
+	names := make([]string, 0, len(sh.vars))
+	for name := range sh.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func shellQuote(s string) string {
+	safe := s != ""
+	for i := 0; i < len(s) && safe; i++ {
+		safe = isNameChar(s[i]) || strings.IndexByte("@%+=:,./-", s[i]) >= 0
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (sh *shell) optionFlags() string {
+	var flags []byte
+	for _, opt := range shellOptions {
+		if sh.options[opt.name] && opt.flag != 0 {
+			flags = append(flags, opt.flag)
+		}
+	}
+	return string(flags)
+}
+
+func builtinExport(sh *shell, args []string, std stdio) int {
+	if len(args) == 1 || (len(args) == 2 && args[1] == "-p") {
+		for _, name := range sh.sortedVarNames() {
+			v := sh.vars[name]
+			if !v.exported {
+				continue
+			}
+			if v.set {
+				fmt.Fprintf(std.out, "export %s=%s\n", name, shellQuote(v.value))
+			} else {
+				fmt.Fprintf(std.out, "export %s\n", name)
+			}
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args[1:] {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !isName(name) {
+			fmt.Fprintf(std.err, "export: '%s': not a valid identifier\n", arg)
+			status = 1
+			continue
+		}
+		if hasValue {
+			sh.setVar(name, value)
+		}
+		sh.exportVar(name)
+	}
+	return status
+}
+
+func builtinUnset(sh *shell, args []string, std stdio) int {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "-v" {
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		if !isName(name) {
+			fmt.Fprintf(std.err, "unset: '%s': not a valid identifier\n", name)
+			status = 1
+			continue
+		}
+		sh.unsetVar(name)
+	}
+	return status
+}
+
+func builtinSet(sh *shell, args []string, std stdio) int {
+	if len(args) == 1 {
+		for _, name := range sh.sortedVarNames() {
+			if v := sh.vars[name]; v.set {
+				fmt.Fprintf(std.out, "%s=%s\n", name, shellQuote(v.value))
+			}
+		}
+		return 0
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || (arg[0] != '-' && arg[0] != '+') {
+			if arg == "--" {
+				i++
+			}
+			sh.params = args[i:]
+			return 0
+		}
+		if len(arg) < 2 {
+			fmt.Fprintf(std.err, "set: %s: invalid option\n", arg)
+			return 2
+		}
+		enable := arg[0] == '-'
+		if arg[1:] == "o" {
+			if i+1 >= len(args) {
+				printOptions(sh, std.out, enable)
+				return 0
+			}
+			i++
+			if !sh.setOption(args[i], enable) {
+				fmt.Fprintf(std.err, "set: %s: invalid option name\n", args[i])
+				return 2
+			}
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			name := ""
+			for _, opt := range shellOptions {
+				if opt.flag == arg[j] {
+					name = opt.name
+				}
+			}
+			if name == "" {
+				fmt.Fprintf(std.err, "set: -%c: invalid option\n", arg[j])
+				return 2
+			}
+			sh.setOption(name, enable)
+		}
+	}
+	return 0
+}
+
+func (sh *shell) setOption(name string, enable bool) bool {
+	for _, opt := range shellOptions {
+		if opt.name == name {
+			sh.options[name] = enable
+			return true
+		}
+	}
+	return false
+}
+
+func printOptions(sh *shell, w io.Writer, human bool) {
+	for _, opt := range shellOptions {
+		state := "off"
+		if sh.options[opt.name] {
+			state = "on"
+		}
+		if human {
+			fmt.Fprintf(w, "%-15s %s\n", opt.name, state)
+		} else if state == "on" {
+			fmt.Fprintf(w, "set -o %s\n", opt.name)
+		} else {
+			fmt.Fprintf(w, "set +o %s\n", opt.name)
+		}
+	}
+}
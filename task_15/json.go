@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"test_15/interp"
+)
+
+// defaultMaxOutput is how much of each stream a JSON record keeps.
+const defaultMaxOutput = 1 << 20
+
+// record is what --json mode writes for each command line.
+type record struct {
+	Line            string    `json:"line"`
+	Commands        []command `json:"commands"`
+	ExitCode        int       `json:"exit_code"`
+	DurationMS      float64   `json:"duration_ms"`
+	Stdout          string    `json:"stdout"`
+	StdoutTruncated bool      `json:"stdout_truncated,omitempty"`
+	Stderr          string    `json:"stderr"`
+	StderrTruncated bool      `json:"stderr_truncated,omitempty"`
+	// Signals lists the signals the shell received while the line ran.
+	Signals []string `json:"signals,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type command struct {
+	Argv     []string `json:"argv"`
+	ExitCode int      `json:"exit_code"`
+	Signal   string   `json:"signal,omitempty"`
+}
+
+// capture keeps the first limit bytes written to it.
+type capture struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(p)
+	if room := c.limit - c.buf.Len(); n > room {
+		p = p[:max(room, 0)]
+		c.truncated = true
+	}
+	c.buf.Write(p)
+	return n, nil
+}
+
+// snapshot returns what has been kept so far and whether any was cut.
+func (c *capture) snapshot() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String(), c.truncated
+}
+
+var jsonSignals = map[os.Signal]string{
+	syscall.SIGINT:  "INT",
+	syscall.SIGTERM: "TERM",
+	syscall.SIGHUP:  "HUP",
+}
+
+// runJSON reads command lines from in, runs each with its output captured
+// and writes a JSON record for it to out. A signal received while a line
+// runs stops that line; TERM and HUP also end the session. Commands read
+// no input. A line's record is written once the jobs it started with &
+// have finished too, so that it holds their output. It returns the exit
+// status of the last line.
+func runJSON(it *interp.Interpreter, in io.Reader, out io.Writer, limit int) int {
+	r := bufio.NewReader(in)
+	enc := json.NewEncoder(out)
+	status := 0
+	for {
+		src, err := readLine(r)
+		if err != nil && src == "" {
+			return status
+		}
+		for err == nil && it.Incomplete(src) {
+			var more string
+			more, err = readLine(r)
+			src += "\n" + more
+		}
+		if strings.TrimSpace(src) == "" {
+			continue
+		}
+
+		rec, sig := runRecord(it, src, limit)
+		enc.Encode(rec)
+		status = rec.ExitCode
+		if sig != 0 {
+			return 128 + int(sig)
+		}
+		if it.Exited() {
+			return status
+		}
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == nil {
+		line = line[:len(line)-1]
+	}
+	return line, err
+}
+
+// runRecord runs one command line and returns its record, and the TERM or
+// HUP signal that should end the session, if one was received.
+func runRecord(it *interp.Interpreter, src string, limit int) (*record, syscall.Signal) {
+	rec := &record{Line: src}
+	var mu sync.Mutex
+	commands := []command{}
+	stdout, stderr := &capture{limit: limit}, &capture{limit: limit}
+	it.Stdin, it.Stdout, it.Stderr = nil, stdout, stderr
+	it.OnCommand = func(c interp.Command) {
+		mu.Lock()
+		commands = append(commands, command{Argv: c.Args, ExitCode: c.Status, Signal: c.Signal})
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	var stop syscall.Signal
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for sig := range sigs {
+			mu.Lock()
+			rec.Signals = append(rec.Signals, jsonSignals[sig])
+			if sig != syscall.SIGINT {
+				stop = sig.(syscall.Signal)
+			}
+			mu.Unlock()
+			cancel()
+		}
+	}()
+
+	start := time.Now()
+	status, err := it.Run(ctx, src)
+	if ctx.Err() == nil {
+		it.Wait(ctx)
+	}
+	elapsed := time.Since(start)
+	signal.Stop(sigs)
+	close(sigs)
+	<-done
+
+	rec.ExitCode = status
+	rec.DurationMS = float64(elapsed.Microseconds()) / 1000
+	if err != nil && ctx.Err() == nil {
+		rec.Error = err.Error()
+	}
+	mu.Lock()
+	rec.Commands = slices.Clone(commands)
+	mu.Unlock()
+	rec.Stdout, rec.StdoutTruncated = stdout.snapshot()
+	rec.Stderr, rec.StderrTruncated = stderr.snapshot()
+	return rec, stop
+}
@@ -2,151 +2,132 @@ package main
 
 import (
 	"bytes"
-	"io"
+	"encoding/json"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"test_15/interp"
 )
 
-func TestParseLine(t *testing.T) {
-	input := `echo "hello world" && ls -l | grep main > out.txt`
-	cmds, err := parseLine(input)
-	if err != nil {
-		t.Fatal("parseLine error:", err)
-	}
-	if len(cmds) != 3 {
-		t.Fatalf("expected 3 commands, got %d", len(cmds))
-	}
-	if cmds[0].args[0] != "echo" || !cmds[0].andNext {
-		t.Error("cmd1 parsing incorrect")
-	}
-	if cmds[1].args[0] != "ls" || !cmds[1].pipeNext {
-		t.Error("cmd2 parsing incorrect")
-	}
-	if cmds[2].args[0] != "grep" || cmds[2].redirectOut != "out.txt" {
-		t.Error("cmd3 parsing or redirect incorrect")
-	}
+func TestMain(m *testing.M) {
+	interp.MaybeExecLimited()
+	os.Exit(m.Run())
 }
 
-func TestTokenize(t *testing.T) {
-	line := `echo "hello world" && ps aux | grep "go run" || echo fail`
-	tokens := tokenize(line)
-	expected := []string{"echo", "hello world", "&&", "ps", "aux", "|", "grep", "go run", "||", "echo", "fail"}
-	if len(tokens) != len(expected) {
-		t.Fatalf("token count mismatch: expected %d, got %d", len(expected), len(tokens))
-	}
-	for i := range tokens {
-		if tokens[i] != expected[i] {
-			t.Errorf("token %d: expected '%s', got '%s'", i, expected[i], tokens[i])
+func TestRunArgs(t *testing.T) {
+	dir := t.TempDir()
+	lib := "greet() { echo \"hi $1\"; }\nLIB=loaded\nreturn 5\necho unreachable\n"
+	script := "#!/bin/sh\n. ./lib.sh\necho $? $LIB $0 $#\ngreet \"$1\"\nif true; then\n  exit\nfi\necho unreachable\n"
+	for name, src := range map[string]string{"lib.sh": lib, "script.sh": script} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
 		}
 	}
-}
-
-func TestSubstituteEnvVars(t *testing.T) {
-	os.Setenv("TESTVAR", "value123")
-	defer os.Unsetenv("TESTVAR")
-
-	line := "echo $TESTVAR and $UNSETVAR"
-	result := substituteEnvVars(line)
-	if !strings.Contains(result, "value123") {
-		t.Error("substituteEnvVars: expected to contain 'value123'")
-	}
-	if strings.Contains(result, "$UNSETVAR") {
-		t.Error("substituteEnvVars: expected unset var to be empty")
+	t.Chdir(dir)
+
+	cases := []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"script.sh", "you", "two"}, 0, "5 loaded script.sh 2\nhi you\n"},
+		{[]string{"-c", "echo $0 $1; (true) && false", "name", "arg"}, 1, "name arg\n"},
+		{[]string{"-c", "if true; then"}, 2, "maxishell: -c: syntax error: 1:14: unexpected end of input\n"},
+		{[]string{"-c"}, 2, "maxishell: -c: option requires an argument\n"},
+		{[]string{"missing.sh"}, 127, "maxishell: open missing.sh: no such file or directory\n"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		it := &interp.Interpreter{Stdin: strings.NewReader(""), Stdout: &out, Stderr: &out}
+		if status := runArgs(it, c.args); status != c.status || out.String() != c.want {
+			t.Errorf("%q: status %d, output %q; want %d, %q", c.args, status, out.String(), c.status, c.want)
+		}
 	}
 }
 
-func TestRunBuiltinEcho(t *testing.T) {
-	cmd := command{args: []string{"echo", "hello", "world"}}
-	old := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	exit := runBuiltin(cmd)
-
-	w.Close()
-	os.Stdout = old
-
-	outBuf := new(bytes.Buffer)
-	io.Copy(outBuf, r)
+func TestJSONMode(t *testing.T) {
+	in := "echo hi | tr a-z A-Z; echo err >&2\n\nif true; then\n  cat\nfi\nsh -c 'kill -KILL $$'\nprintf abcdefgh\nfi\nexit 4\necho never\n"
+	var out bytes.Buffer
+	it := &interp.Interpreter{Stdin: strings.NewReader(in), Stdout: &out, Stderr: &out}
+	if status := runArgs(it, []string{"--json", "--max-output=6"}); status != 4 {
+		t.Errorf("status %d; want 4", status)
+	}
 
-	if exit != 0 {
-		t.Errorf("runBuiltin echo exit code = %d, want 0", exit)
+	type rec struct {
+		Line     string
+		Commands []struct {
+			Argv     []string
+			ExitCode int `json:"exit_code"`
+			Signal   string
+		}
+		ExitCode        int     `json:"exit_code"`
+		DurationMS      float64 `json:"duration_ms"`
+		Stdout, Stderr  string
+		StdoutTruncated bool `json:"stdout_truncated"`
+		Error           string
+	}
+	var recs []rec
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r rec
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, r)
 	}
-	if !strings.Contains(outBuf.String(), "hello world") {
-		t.Errorf("runBuiltin echo output = %q, want to contain 'hello world'", outBuf.String())
+	if len(recs) != 6 {
+		t.Fatalf("got %d records: %+v", len(recs), recs)
 	}
-}
 
-func TestRunBuiltinCd(t *testing.T) {
-	oldDir, _ := os.Getwd()
-	defer os.Chdir(oldDir)
-
-	tempDir := os.TempDir()
-
-	err := os.Chdir(tempDir)
-	if err != nil {
-		t.Fatal(err)
+	r := recs[0]
+	if r.Stdout != "HI\n" || r.Stderr != "err\n" || r.ExitCode != 0 || r.DurationMS <= 0 || len(r.Commands) != 3 ||
+		!slices.Equal(r.Commands[0].Argv, []string{"echo", "hi"}) || !slices.Equal(r.Commands[1].Argv, []string{"tr", "a-z", "A-Z"}) {
+		t.Errorf("pipeline record %+v", r)
 	}
-
-	cmd := command{args: []string{"cd", oldDir}}
-	exit := runBuiltin(cmd)
-	if exit != 0 {
-		t.Fatal("cd command failed")
+	if r := recs[1]; r.Line != "if true; then\n  cat\nfi" || r.Stdout != "" || r.ExitCode != 0 {
+		t.Errorf("multi-line record %+v", r)
 	}
-
-	cwd, _ := os.Getwd()
-	realCwd, _ := filepath.EvalSymlinks(cwd)
-	realOldDir, _ := filepath.EvalSymlinks(oldDir)
-
-	if realCwd != realOldDir {
-		t.Errorf("expected cwd %q, got %q", realOldDir, realCwd)
+	if r := recs[2]; r.ExitCode != 137 || len(r.Commands) != 1 || r.Commands[0].Signal != "KILL" {
+		t.Errorf("killed record %+v", r)
 	}
-}
-
-func TestRunExternal(t *testing.T) {
-	path, err := exec.LookPath("echo")
-	if err != nil {
-		t.Skip("echo command not found")
+	if r := recs[3]; r.Stdout != "abcdef" || !r.StdoutTruncated {
+		t.Errorf("truncated record %+v", r)
 	}
-	cmd := command{args: []string{path, "testexternal"}}
-	execCmd, err := runExternal(cmd, nil)
-	if err != nil {
-		t.Fatalf("runExternal error: %v", err)
+	if r := recs[4]; r.ExitCode != 2 || r.Error == "" {
+		t.Errorf("syntax error record %+v", r)
 	}
-	exitCode := waitCommand(execCmd)
-	t.Logf("exit code: %d", exitCode)
-	if exitCode != 0 {
-		t.Errorf("expected exit code 0, got %d", exitCode)
+	if r := recs[5]; r.Line != "exit 4" || r.ExitCode != 4 {
+		t.Errorf("exit record %+v", r)
 	}
 }
 
-func TestRunFullPipeline(t *testing.T) {
-	cmds := []command{
-		{args: []string{"echo", "hello"}},
-		{args: []string{"grep", "he"}},
+func TestJSONModeSignals(t *testing.T) {
+	in := "kill -INT $$; sleep 5\nkill -TERM $$; sleep 5\necho never\n"
+	var out bytes.Buffer
+	it := &interp.Interpreter{Stdin: strings.NewReader(in), Stdout: &out, Stderr: &out}
+	start := time.Now()
+	if status := runArgs(it, []string{"--json"}); status != 143 || time.Since(start) > 3*time.Second {
+		t.Errorf("status %d after %v; want 143", status, time.Since(start))
 	}
-
-	exitCode := runFullPipeline(cmds)
-	if exitCode != 0 {
-		t.Errorf("runFullPipeline exit code = %d; want 0", exitCode)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"signals":["INT"]`) || !strings.Contains(lines[1], `"signals":["TERM"]`) {
+		t.Errorf("records:\n%s", out.String())
 	}
 }
 
-func TestRunBuiltinKillInvalid(t *testing.T) {
-	cmd := command{args: []string{"kill", "abc"}}
-	exit := runBuiltin(cmd)
-	if exit == 0 {
-		t.Error("kill with invalid pid should fail")
+func TestJSONModeBackgroundJob(t *testing.T) {
+	in := "(for i in 1 2 3; do echo $i; /bin/sleep 0.05; done) &\necho next\n"
+	var out bytes.Buffer
+	it := &interp.Interpreter{Stdin: strings.NewReader(in), Stdout: &out, Stderr: &out}
+	if status := runArgs(it, []string{"--json"}); status != 0 {
+		t.Errorf("status %d", status)
 	}
-}
-
-func TestRunBuiltinPs(t *testing.T) {
-	cmd := command{args: []string{"ps"}}
-	exit := runBuiltin(cmd)
-	if exit != 0 {
-		t.Error("ps command failed")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"stdout":"1\n2\n3\n"`) || !strings.Contains(lines[1], `"stdout":"next\n"`) {
+		t.Errorf("records:\n%s", out.String())
 	}
 }
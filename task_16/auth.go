@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// authTransport adds the credentials and extra headers to requests for
+// BaseURL's origin only, so that neither links nor redirects to other
+// hosts carry them.
+type authTransport struct {
+	base http.RoundTripper
+	d    *Downloader
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d := t.d
+	if !d.sameOrigin(req.URL) {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, values := range d.Headers {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	switch {
+	case d.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+d.BearerToken)
+	case d.Username != "" || d.Password != "":
+		req.SetBasicAuth(d.Username, d.Password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// sameOrigin reports whether u has BaseURL's scheme, host and port.
+func (d *Downloader) sameOrigin(u *url.URL) bool {
+	return strings.EqualFold(u.Scheme, d.BaseURL.Scheme) && strings.EqualFold(u.Host, d.BaseURL.Host)
+}
+
+// setupClient gives Client a cookie jar, loaded from CookieFile if set,
+// and the credentials and headers for BaseURL, then logs in if LoginURL
+// is set.
+func (d *Downloader) setupClient() error {
+	client := *d.Client
+	if client.Jar == nil {
+		jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		if err != nil {
+			return err
+		}
+		client.Jar = jar
+	}
+	if d.CookieFile != "" {
+		f, err := os.Open(d.CookieFile)
+		if err != nil {
+			return err
+		}
+		err = loadCookies(client.Jar, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.CookieFile, err)
+		}
+	}
+	if len(d.Headers) > 0 || d.Username != "" || d.Password != "" || d.BearerToken != "" {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &authTransport{base: base, d: d}
+	}
+	d.Client = &client
+
+	if d.LoginURL != "" {
+		return d.login()
+	}
+	return nil
+}
+
+// login posts LoginForm to LoginURL, keeping the session cookies it sets.
+func (d *Downloader) login() error {
+	req, err := http.NewRequest(http.MethodPost, d.LoginURL, strings.NewReader(d.LoginForm.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", d.UserAgent)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login to %s: %s", d.LoginURL, resp.Status)
+	}
+	return nil
+}
+
+// loadCookies adds the cookies in a Netscape cookies.txt file to jar.
+// Each line holds a domain, whether subdomains match, a path, whether the
+// cookie is secure, its expiry as a Unix time (0 for a session cookie),
+// its name and its value, separated by tabs.
+func loadCookies(jar http.CookieJar, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimRight(sc.Text(), "\r")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(text, "#HttpOnly_"); ok {
+			text, httpOnly = rest, true
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("line %d: want 7 tab-separated fields, have %d", line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: bad expiry %q", line, fields[4])
+		}
+
+		domain := fields[0]
+		host := strings.TrimPrefix(domain, ".")
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	return sc.Err()
+}
+
+// headerFlag collects repeated -header "Name: value" flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string { return "" }
+
+func (h headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("want Name: value, have %q", s)
+	}
+	http.Header(h).Add(name, strings.TrimSpace(value))
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadCookies(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	file := fmt.Sprintf(`# Netscape HTTP Cookie File
+.example.com	TRUE	/	FALSE	%[1]d	wide	1
+example.com	FALSE	/docs	FALSE	0	docs	2
+#HttpOnly_example.com	FALSE	/	TRUE	%[1]d	secret	3
+example.com	FALSE	/	FALSE	1	stale	4
+`, future)
+	jar, _ := cookiejar.New(nil)
+	if err := loadCookies(jar, strings.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", "wide=1"},
+		{"http://www.example.com/docs/a", "wide=1"},
+		{"http://example.com/docs/a", "docs=2 wide=1"},
+		{"https://example.com/", "secret=3 wide=1"},
+		{"http://other.com/", ""},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+		var names []string
+		for _, cookie := range jar.Cookies(u) {
+			names = append(names, cookie.Name+"="+cookie.Value)
+		}
+		slices.Sort(names)
+		if got := strings.Join(names, " "); got != c.want {
+			t.Errorf("cookies for %s: %q; want %q", c.url, got, c.want)
+		}
+	}
+
+	if err := loadCookies(jar, strings.NewReader("example.com\tTRUE\t/\n")); err == nil {
+		t.Errorf("short line loaded without an error")
+	}
+}
+
+func TestHeaderFlag(t *testing.T) {
+	h := headerFlag{}
+	for _, s := range []string{"X-Team: docs", "accept-language:en", "X-Team: web"} {
+		if err := h.Set(s); err != nil {
+			t.Errorf("Set(%q): %v", s, err)
+		}
+	}
+	if got := http.Header(h); strings.Join(got.Values("X-Team"), ",") != "docs,web" || got.Get("Accept-Language") != "en" {
+		t.Errorf("headers %v", got)
+	}
+	if err := h.Set("no colon"); err == nil {
+		t.Errorf("Set without a colon succeeded")
+	}
+}
+
+func TestMirrorAuthAndCookies(t *testing.T) {
+	var mu sync.Mutex
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		for _, name := range []string{"Authorization", "X-Team", "Cookie"} {
+			if v := r.Header.Get(name); v != "" {
+				leaked = append(leaked, r.URL.Path+" "+name+": "+v)
+			}
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "png")
+	}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	cases := []struct {
+		name  string
+		setup func(d *Downloader)
+		auth  string
+	}{
+		{"basic", func(d *Downloader) { d.Username, d.Password = "ann", "pw" }, "Basic YW5uOnB3"},
+		{"bearer", func(d *Downloader) { d.BearerToken = "tok" }, "Bearer tok"},
+	}
+	for _, c := range cases {
+		leaked = nil
+		var denied []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/login" {
+				r.ParseForm()
+				if r.Method != http.MethodPost || r.PostForm.Get("user") != "ann" {
+					http.Error(w, "bad login", http.StatusForbidden)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			session, _ := r.Cookie("session")
+			pref, _ := r.Cookie("pref")
+			if r.Header.Get("Authorization") != c.auth || r.Header.Get("X-Team") != "docs" ||
+				session == nil || pref == nil {
+				mu.Lock()
+				denied = append(denied, r.URL.Path)
+				mu.Unlock()
+				http.Error(w, "denied", http.StatusUnauthorized)
+				return
+			}
+			switch r.URL.Path {
+			case "/away.png":
+				http.Redirect(w, r, otherURL+"/redirected.png", http.StatusFound)
+			default:
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprintf(w, `<img src="/away.png"><img src="%s/linked.png">`, otherURL)
+			}
+		}))
+
+		cookies := filepath.Join(t.TempDir(), "cookies.txt")
+		os.WriteFile(cookies, []byte("127.0.0.1\tFALSE\t/\tFALSE\t0\tpref\tdark\n"), 0o644)
+		d, _ := NewDownloader(srv.URL, t.TempDir(), 1, 4)
+		d.IgnoreRobots = true
+		d.Headers = http.Header{"X-Team": {"docs"}}
+		d.CookieFile = cookies
+		d.LoginURL = srv.URL + "/login"
+		d.LoginForm = url.Values{"user": {"ann"}}
+		c.setup(d)
+		d.Start()
+		srv.Close()
+
+		if len(d.Errors) != 0 || len(denied) != 0 {
+			t.Errorf("%s: errors %v, denied %q", c.name, d.Errors, denied)
+		}
+		if len(leaked) != 0 {
+			t.Errorf("%s: credentials sent to another host: %q", c.name, leaked)
+		}
+		if got := d.state.get(otherURL + "/linked.png"); !got.Complete {
+			t.Errorf("%s: linked.png on the other host not downloaded", c.name)
+		}
+	}
+}
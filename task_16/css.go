@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	cssURLRe    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	cssImportRe = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// rewriteCSS replaces each url(...) and @import reference in css with
+// what f returns for it, keeping the quoting and spacing around it.
+func rewriteCSS(css string, f func(string) string) string {
+	for _, re := range []*regexp.Regexp{cssURLRe, cssImportRe} {
+		var b strings.Builder
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(css, -1) {
+			for g := 2; g < len(m); g += 2 {
+				if m[g] < 0 {
+					continue
+				}
+				b.WriteString(css[last:m[g]])
+				b.WriteString(f(css[m[g]:m[g+1]]))
+				last = m[g+1]
+				break
+			}
+		}
+		b.WriteString(css[last:])
+		css = b.String()
+	}
+	return css
+}
+
+// cssLinks returns the references in a stylesheet.
+func cssLinks(css string) []string {
+	var links []string
+	rewriteCSS(css, func(ref string) string {
+		links = append(links, ref)
+		return ref
+	})
+	return links
+}
+
+// rewriteSrcset replaces the URL of each image candidate in a srcset
+// attribute with what f returns for it.
+func rewriteSrcset(srcset string, f func(string) string) string {
+	var out []string
+	for rest := srcset; ; {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if rest == "" {
+			break
+		}
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		ref, desc := rest[:end], ""
+		rest = rest[end:]
+		if trimmed := strings.TrimRight(ref, ","); trimmed != ref {
+			ref = trimmed
+		} else if i := strings.IndexByte(rest, ','); i >= 0 {
+			desc, rest = strings.TrimSpace(rest[:i]), rest[i+1:]
+		} else {
+			desc, rest = strings.TrimSpace(rest), ""
+		}
+		candidate := f(ref)
+		if desc != "" {
+			candidate += " " + desc
+		}
+		out = append(out, candidate)
+	}
+	return strings.Join(out, ", ")
+}
+
+// rewriteRefresh replaces the URL in the content of a
+// <meta http-equiv="refresh"> tag, such as "5; url=next.html".
+func rewriteRefresh(content string, f func(string) string) string {
+	i := strings.Index(strings.ToLower(content), "url=")
+	if i < 0 {
+		return content
+	}
+	ref := strings.TrimSpace(content[i+len("url="):])
+	if len(ref) >= 2 && (ref[0] == '"' || ref[0] == '\'') && ref[len(ref)-1] == ref[0] {
+		ref = ref[1 : len(ref)-1]
+	}
+	if ref == "" {
+		return content
+	}
+	return content[:i] + "url=" + f(ref)
+}
+
+// eachLink calls f with every URL a page refers to, in href, src and
+// srcset attributes, style attributes and <style> blocks and a meta
+// refresh, and replaces the reference with what f returns. requisite
+// tells whether the page needs the URL to display, rather than links to
+// it.
+func eachLink(n *html.Node, f func(ref string, requisite bool) string) {
+	if n.Type == html.ElementNode {
+		refresh := n.DataAtom == atom.Meta && strings.EqualFold(attrValue(n, "http-equiv"), "refresh")
+		asset := func(ref string) string { return f(ref, true) }
+		for i, attr := range n.Attr {
+			if attr.Val == "" {
+				continue
+			}
+			switch {
+			case attr.Key == "href":
+				n.Attr[i].Val = f(attr.Val, n.DataAtom == atom.Link && requisiteRel(attrValue(n, "rel")))
+			case attr.Key == "src":
+				n.Attr[i].Val = f(attr.Val, n.DataAtom != atom.Iframe && n.DataAtom != atom.Frame)
+			case attr.Key == "srcset":
+				n.Attr[i].Val = rewriteSrcset(attr.Val, asset)
+			case attr.Key == "style":
+				n.Attr[i].Val = rewriteCSS(attr.Val, asset)
+			case attr.Key == "content" && refresh:
+				n.Attr[i].Val = rewriteRefresh(attr.Val, func(ref string) string { return f(ref, false) })
+			}
+		}
+		if n.DataAtom == atom.Style {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					c.Data = rewriteCSS(c.Data, asset)
+				}
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		eachLink(c, f)
+	}
+}
+
+// requisiteRel reports whether a <link> with this rel is needed to show
+// the page, like a stylesheet or an icon, rather than a link like next.
+func requisiteRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		switch r {
+		case "stylesheet", "icon", "apple-touch-icon", "preload", "modulepreload", "manifest":
+			return true
+		}
+	}
+	return false
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// fetchableRef reports whether ref is something we could download, rather
+// than an inline data: URI, a fragment or another scheme like mailto:.
+func fetchableRef(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return false
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https"
+}
+
+// stylesheetLinks returns the references in a stylesheet fetched from
+// cssURL, resolved against it, and the stylesheet with the references to
+// our own site rewritten to point into the mirror relative to localPath.
+func (d *Downloader) stylesheetLinks(cssURL, css, localPath string) ([]crawlLink, string) {
+	base, err := url.Parse(cssURL)
+	if err != nil {
+		return nil, css
+	}
+	var links []crawlLink
+	css = rewriteCSS(css, func(ref string) string {
+		if absurl, ok := resolveRef(base, ref); ok {
+			links = append(links, crawlLink{url: absurl, requisite: true})
+		}
+		return d.localRef(cssURL, localPath, base, ref, true)
+	})
+	return links, css
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRewriteCSS(t *testing.T) {
+	upper := func(ref string) string { return strings.ToUpper(ref) }
+	cases := []struct {
+		css  string
+		want string
+	}{
+		{`body { background: url(img/bg.png) }`, `body { background: url(IMG/BG.PNG) }`},
+		{`a { b: url( "x.png" ); c: url('y.png') }`, `a { b: url( "X.PNG" ); c: url('Y.PNG') }`},
+		{`@import "base.css"; @import url(print.css) print;`, `@import "BASE.CSS"; @import url(PRINT.CSS) print;`},
+		{`@import 'a.css';`, `@import 'A.CSS';`},
+		{`p { color: red }`, `p { color: red }`},
+	}
+	for _, c := range cases {
+		if got := rewriteCSS(c.css, upper); got != c.want {
+			t.Errorf("rewriteCSS(%q) = %q; want %q", c.css, got, c.want)
+		}
+	}
+}
+
+func TestRewriteSrcsetAndRefresh(t *testing.T) {
+	upper := func(ref string) string { return strings.ToUpper(ref) }
+	srcsets := []struct {
+		in   string
+		want string
+	}{
+		{"a.png", "A.PNG"},
+		{"a.png 1x, b.png 2x", "A.PNG 1x, B.PNG 2x"},
+		{" a.png  480w,\n b.png 800w ", "A.PNG 480w, B.PNG 800w"},
+		{"a.png, b.png 2x,", "A.PNG, B.PNG 2x"},
+		{"img?w=1,2.png 1x", "IMG?W=1,2.PNG 1x"},
+	}
+	for _, c := range srcsets {
+		if got := rewriteSrcset(c.in, upper); got != c.want {
+			t.Errorf("rewriteSrcset(%q) = %q; want %q", c.in, got, c.want)
+		}
+	}
+
+	refreshes := []struct {
+		in   string
+		want string
+	}{
+		{"5; url=next.html", "5; url=NEXT.HTML"},
+		{"0;URL='next.html'", "0;url=NEXT.HTML"},
+		{"30", "30"},
+	}
+	for _, c := range refreshes {
+		if got := rewriteRefresh(c.in, upper); got != c.want {
+			t.Errorf("rewriteRefresh(%q) = %q; want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCollectAssetLinks(t *testing.T) {
+	htmlStr := `<html><head>
+<meta http-equiv="Refresh" content="10; url=/next">
+<meta name="description" content="url=/not-a-link">
+<style>@import "/css/a.css"; body { background: url(/img/bg.png) }</style>
+</head><body>
+<div style="background-image: url('/img/div.png')"></div>
+<img src="/img/small.png" srcset="/img/small.png 1x, /img/big.png 2x">
+<a href="mailto:me@example.com">mail</a><a href="#top">top</a>
+<img src="data:image/png;base64,AAAA">
+</body></html>`
+	doc, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := NewDownloader("https://example.com", "mirror", 1, 1)
+	links := d.collectLinks(doc)
+	slices.Sort(links)
+	want := []string{"/css/a.css", "/img/bg.png", "/img/big.png", "/img/div.png", "/img/small.png", "/img/small.png", "/next"}
+	if !slices.Equal(links, want) {
+		t.Errorf("collectLinks = %q; want %q", links, want)
+	}
+}
+
+func TestMirrorStylesheets(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<link rel="stylesheet" href="/css/site.css"><img srcset="/img/a.png 1x, /img/b.png 2x">`)
+		case strings.HasSuffix(r.URL.Path, ".css"):
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `@import "print.css"; body { background: url("../img/bg.png") } i { background: url(data:image/png;base64,AA) }`)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	d, _ := NewDownloader(srv.URL, root, 3, 8)
+	d.IgnoreRobots = true
+	d.Start()
+	slices.Sort(fetched)
+	want := []string{"/", "/css/print.css", "/css/site.css", "/img/a.png", "/img/b.png", "/img/bg.png"}
+	if len(d.Errors) != 0 || !slices.Equal(fetched, want) {
+		t.Fatalf("fetched %q, errors %v; want %q", fetched, d.Errors, want)
+	}
+
+	css, err := os.ReadFile(filepath.Join(root, "127.0.0.1", "css", "site.css"))
+	wantCSS := `@import "print.css"; body { background: url("../img/bg.png") } i { background: url(data:image/png;base64,AA) }`
+	if err != nil || string(css) != wantCSS {
+		t.Errorf("site.css = %q, %v; want %q", css, err, wantCSS)
+	}
+	page, _ := os.ReadFile(filepath.Join(root, "127.0.0.1", "index.html"))
+	if strings.Contains(string(page), "http") || !strings.Contains(string(page), "img/b.png 2x") {
+		t.Errorf("index.html not rewritten: %s", page)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// crawlLink is a link found in a page or stylesheet. Requisites are what
+// a page needs to display, such as stylesheets, scripts and images; they
+// are fetched from any host and whatever the directory filters say.
+type crawlLink struct {
+	url       string
+	requisite bool
+}
+
+// wanted reports whether the filters let us download absurl.
+func (d *Downloader) wanted(absurl string, requisite bool) bool {
+	u, err := url.Parse(absurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := u.Hostname()
+	if matchDomain(host, d.ExcludeDomains) {
+		return false
+	}
+	if matchPath(u.Path, d.Reject) || d.RejectRegex != nil && d.RejectRegex.MatchString(u.Path) {
+		return false
+	}
+	if requisite {
+		return true
+	}
+
+	if !d.isInDomain(absurl) && !(d.SpanHosts && (len(d.Domains) == 0 || matchDomain(host, d.Domains))) {
+		return false
+	}
+	if d.NoParent && d.isInDomain(absurl) && !strings.HasPrefix(u.Path, d.BaseURL.Path) {
+		return false
+	}
+	dir := u.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	if len(d.IncludeDirs) > 0 && !matchDir(dir, d.IncludeDirs) || matchDir(dir, d.ExcludeDirs) {
+		return false
+	}
+	// Pages are always followed for their links, as wget does.
+	if !looksLikePage(u.Path) {
+		if len(d.Accept) > 0 && !matchPath(u.Path, d.Accept) {
+			return false
+		}
+		if d.AcceptRegex != nil && !d.AcceptRegex.MatchString(u.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchDomain reports whether host is one of domains or below one.
+func matchDomain(host string, domains []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDir reports whether dir is one of dirs or below one. The
+// directories may contain glob patterns.
+func matchDir(dir string, dirs []string) bool {
+	segs := strings.Split(strings.Trim(dir, "/"), "/")
+	for _, pattern := range dirs {
+		pattern = strings.Trim(pattern, "/")
+		if pattern == "" {
+			return true
+		}
+		n := strings.Count(pattern, "/") + 1
+		if n > len(segs) || segs[0] == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, strings.Join(segs[:n], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath reports whether a glob pattern matches the file name of p, or
+// the whole of p if the pattern has a slash.
+func matchPath(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		subject := path.Base(p)
+		if strings.Contains(pattern, "/") {
+			subject = p
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikePage reports whether a URL path is probably an HTML page: a
+// directory, a name without an extension or one ending in .html or .htm.
+func looksLikePage(p string) bool {
+	ext := path.Ext(path.Base(p))
+	return strings.HasSuffix(p, "/") || ext == "" || ext == ".html" || ext == ".htm"
+}
+
+var errTooLarge = errors.New("larger than the maximum file size")
+
+// sizeLimitReader fails with errTooLarge once more than n bytes are read.
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (d *Downloader) skipTooLarge(rawurl string) {
+	d.logf("Skipping %s (%v of %d bytes)", rawurl, errTooLarge, d.MaxFileSize)
+}
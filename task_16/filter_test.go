@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWanted(t *testing.T) {
+	cases := []struct {
+		name      string
+		setup     func(d *Downloader)
+		url       string
+		requisite bool
+		want      bool
+	}{
+		{"same host", nil, "https://example.com/a.html", false, true},
+		{"other host", nil, "https://cdn.net/a.html", false, false},
+		{"requisite on cdn", nil, "https://cdn.net/app.js", true, true},
+		{"span hosts", func(d *Downloader) { d.SpanHosts = true }, "https://cdn.net/a.html", false, true},
+		{"domains", func(d *Downloader) { d.SpanHosts, d.Domains = true, []string{"example.org"} }, "https://docs.example.org/", false, true},
+		{"not in domains", func(d *Downloader) { d.SpanHosts, d.Domains = true, []string{"example.org"} }, "https://cdn.net/", false, false},
+		{"exclude domains", func(d *Downloader) { d.ExcludeDomains = []string{"ads.net"} }, "https://x.ads.net/banner.png", true, false},
+		{"include dirs", func(d *Downloader) { d.IncludeDirs = []string{"/docs"} }, "https://example.com/docs/api/x.html", false, true},
+		{"not included", func(d *Downloader) { d.IncludeDirs = []string{"/docs"} }, "https://example.com/blog/x.html", false, false},
+		{"included glob", func(d *Downloader) { d.IncludeDirs = []string{"/v*/docs"} }, "https://example.com/v2/docs/", false, true},
+		{"exclude dirs", func(d *Downloader) { d.ExcludeDirs = []string{"/private"} }, "https://example.com/private/x", false, false},
+		{"excluded dir requisite", func(d *Downloader) { d.ExcludeDirs = []string{"/static"} }, "https://example.com/static/a.css", true, true},
+		{"accept", func(d *Downloader) { d.Accept = []string{"*.pdf"} }, "https://example.com/doc.pdf", false, true},
+		{"not accepted", func(d *Downloader) { d.Accept = []string{"*.pdf"} }, "https://example.com/doc.zip", false, false},
+		{"pages followed despite accept", func(d *Downloader) { d.Accept = []string{"*.pdf"} }, "https://example.com/list", false, true},
+		{"reject", func(d *Downloader) { d.Reject = []string{"*.iso"} }, "https://example.com/big.iso", false, false},
+		{"reject requisite", func(d *Downloader) { d.Reject = []string{"*.gif"} }, "https://cdn.net/a.gif", true, false},
+		{"reject path glob", func(d *Downloader) { d.Reject = []string{"/tmp/*"} }, "https://example.com/tmp/a.html", false, false},
+		{"accept regex", func(d *Downloader) { d.AcceptRegex = regexp.MustCompile(`\.(pdf|txt)$`) }, "https://example.com/a.txt", false, true},
+		{"reject regex", func(d *Downloader) { d.RejectRegex = regexp.MustCompile(`/print/`) }, "https://example.com/print/a.html", false, false},
+		{"no parent", func(d *Downloader) { d.NoParent = true }, "https://example.com/other/", false, false},
+		{"no parent below", func(d *Downloader) { d.NoParent = true }, "https://example.com/dir/sub/", false, true},
+		{"no parent requisite", func(d *Downloader) { d.NoParent = true }, "https://example.com/style.css", true, true},
+		{"mailto", nil, "mailto:me@example.com", false, false},
+	}
+	for _, c := range cases {
+		d, _ := NewDownloader("https://example.com/dir/", "mirror", 1, 1)
+		if c.setup != nil {
+			c.setup(d)
+		}
+		if got := d.wanted(c.url, c.requisite); got != c.want {
+			t.Errorf("%s: wanted(%q, %v) = %v; want %v", c.name, c.url, c.requisite, got, c.want)
+		}
+	}
+}
+
+func TestMirrorFilters(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	record := func(r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.Host[:strings.IndexByte(r.Host, ':')]+r.URL.Path)
+		mu.Unlock()
+	}
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "cdn")
+	}))
+	defer cdn.Close()
+	cdnURL := strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<script src="%s/lib.js"></script><a href="%s/page.html">cdn page</a>`+
+				`<a href="/big.bin">big</a><a href="/small.bin">small</a><a href="/skip.iso">iso</a>`, cdnURL, cdnURL)
+		case "/big.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, strings.Repeat("x", 1000))
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, "small")
+		}
+	}))
+	defer srv.Close()
+
+	d, _ := NewDownloader(srv.URL, t.TempDir(), 2, 8)
+	d.IgnoreRobots = true
+	d.MaxFileSize = 500
+	d.Reject = []string{"*.iso"}
+	d.Start()
+	slices.Sort(fetched)
+	want := []string{"127.0.0.1/", "127.0.0.1/big.bin", "127.0.0.1/small.bin", "localhost/lib.js"}
+	if len(d.Errors) != 0 || !slices.Equal(fetched, want) {
+		t.Errorf("fetched %q, errors %v; want %q", fetched, d.Errors, want)
+	}
+	if d.state.get(srv.URL + "/big.bin").Complete {
+		t.Errorf("big.bin saved despite MaxFileSize")
+	}
+}
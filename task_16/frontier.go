@@ -0,0 +1,102 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// defaultPriority is the priority of URLs found by crawling, the same as
+// the default for sitemap entries.
+const defaultPriority = 0.5
+
+// frontierItem is a URL waiting to be downloaded.
+type frontierItem struct {
+	url       string
+	depth     int
+	priority  float64
+	requisite bool
+	seq       int
+}
+
+// frontier is the queue of URLs to download. Higher priorities come
+// first, then lower depths, so that the crawl is breadth first, then the
+// order they were queued in.
+type frontier struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  frontierHeap
+	seq    int
+	active int
+}
+
+func newFrontier() *frontier {
+	f := &frontier{}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *frontier) push(item frontierItem) {
+	f.mu.Lock()
+	item.seq = f.seq
+	f.seq++
+	heap.Push(&f.items, item)
+	f.mu.Unlock()
+	f.cond.Signal()
+}
+
+// pop waits for the next URL. It reports false once the queue is empty
+// and no URL taken from it is still being worked on, since nothing more
+// can then be queued.
+func (f *frontier) pop() (frontierItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.items) == 0 {
+		if f.active == 0 {
+			f.cond.Broadcast()
+			return frontierItem{}, false
+		}
+		f.cond.Wait()
+	}
+	f.active++
+	return heap.Pop(&f.items).(frontierItem), true
+}
+
+// finish marks a URL taken with pop as done, after the links found in it
+// have been queued.
+func (f *frontier) finish() {
+	f.mu.Lock()
+	f.active--
+	f.mu.Unlock()
+	f.cond.Broadcast()
+}
+
+func (f *frontier) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.items)
+}
+
+type frontierHeap []frontierItem
+
+func (h frontierHeap) Len() int { return len(h) }
+
+func (h frontierHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	if h[i].depth != h[j].depth {
+		return h[i].depth < h[j].depth
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h frontierHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *frontierHeap) Push(x any) { *h = append(*h, x.(frontierItem)) }
+
+func (h *frontierHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
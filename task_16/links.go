@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxQueryName is the longest escaped query string kept in a file name;
+// longer ones are replaced by a hash.
+const maxQueryName = 64
+
+// queryFileName puts the query string of a URL into the file name name,
+// before its extension: page.html with a=1 becomes page@a=1.html.
+func queryFileName(name, rawQuery string) string {
+	q := url.PathEscape(rawQuery)
+	if len(q) > maxQueryName {
+		sum := sha256.Sum256([]byte(rawQuery))
+		q = hex.EncodeToString(sum[:8])
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "@" + q + ext
+}
+
+// resolveRef resolves a reference found in a page or stylesheet at base,
+// dropping the fragment. It reports false for references we would not
+// download, such as data: URIs and fragments.
+func resolveRef(base *url.URL, ref string) (string, bool) {
+	if !fetchableRef(ref) {
+		return "", false
+	}
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// documentBase returns the URL links in a page are relative to: that of
+// its <base href> if it has one, else the page's own.
+func documentBase(doc *html.Node, pageURL *url.URL) *url.URL {
+	var base *url.URL
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if base != nil {
+			return
+		}
+		if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+			if href := attrValue(n, "href"); href != "" {
+				if u, err := pageURL.Parse(href); err == nil {
+					base = u
+					return
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+	if base == nil {
+		return pageURL
+	}
+	return base
+}
+
+// localPathFor returns where absurl is saved in the mirror. It reports
+// false if absurl has not been downloaded yet, in which case the path is
+// a guess from the URL alone: HTML unless the last segment has an
+// extension.
+func (d *Downloader) localPathFor(absurl string) (string, bool) {
+	if ct := d.state.get(absurl).ContentType; ct != "" {
+		return d.urlToFilePath(absurl, isHTMLType(ct)), true
+	}
+	u, err := url.Parse(absurl)
+	if err != nil {
+		return d.urlToFilePath(absurl, false), false
+	}
+	ext := path.Ext(u.Path)
+	return d.urlToFilePath(absurl, ext == "" || ext == ".html" || ext == ".htm"), false
+}
+
+// guessedLinks are the links in a file, fetched from pageURL, that were
+// made before their targets were downloaded, and the URLs they stand for.
+type guessedLinks struct {
+	pageURL string
+	links   map[string]string
+}
+
+// localLink returns the link to absurl from the file fetched from pageURL
+// and saved at fromPath, with fragment appended if it is not empty. A
+// link made from a guessed path is noted so that fixLinks can correct it
+// later.
+func (d *Downloader) localLink(pageURL, fromPath, absurl, fragment string) (string, bool) {
+	target, known := d.localPathFor(absurl)
+	rel, err := filepath.Rel(filepath.Dir(fromPath), target)
+	if err != nil {
+		return "", false
+	}
+	link := (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+	if !known {
+		d.guessMu.Lock()
+		if d.guesses == nil {
+			d.guesses = make(map[string]*guessedLinks)
+		}
+		if d.guesses[fromPath] == nil {
+			d.guesses[fromPath] = &guessedLinks{pageURL: pageURL, links: make(map[string]string)}
+		}
+		d.guesses[fromPath].links[link] = absurl
+		d.guessMu.Unlock()
+	}
+	if fragment != "" {
+		link += "#" + fragment
+	}
+	return link, true
+}
+
+// localRef rewrites a reference in the file fetched from pageURL and saved
+// at fromPath, resolved against base, to point into the mirror if the
+// filters let us download it.
+func (d *Downloader) localRef(pageURL, fromPath string, base *url.URL, ref string, requisite bool) string {
+	absurl, ok := resolveRef(base, ref)
+	if !ok || !d.wanted(absurl, requisite) {
+		return ref
+	}
+	fragment := ""
+	if u, err := url.Parse(strings.TrimSpace(ref)); err == nil {
+		fragment = u.Fragment
+	}
+	link, ok := d.localLink(pageURL, fromPath, absurl, fragment)
+	if !ok {
+		return ref
+	}
+	return link
+}
+
+// fixLinks corrects links written before their targets were downloaded,
+// where the target turned out to be saved somewhere other than guessed,
+// for instance an extensionless URL that was not HTML.
+func (d *Downloader) fixLinks() {
+	d.guessMu.Lock()
+	guesses := d.guesses
+	d.guesses = nil
+	d.guessMu.Unlock()
+
+	for fromPath, guessed := range guesses {
+		fixes := make(map[string]string)
+		for link, absurl := range guessed.links {
+			target, known := d.localPathFor(absurl)
+			if !known {
+				continue
+			}
+			rel, err := filepath.Rel(filepath.Dir(fromPath), target)
+			if err != nil {
+				continue
+			}
+			if fixed := (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath(); fixed != link {
+				fixes[link] = fixed
+			}
+		}
+		if len(fixes) > 0 {
+			contentType := d.state.get(guessed.pageURL).ContentType
+			if err := fixFile(fromPath, contentType, fixes); err != nil {
+				d.appendError(err)
+			}
+		}
+	}
+}
+
+// fixFile replaces the links in an HTML page or stylesheet, as told by
+// contentType, that fixes maps to corrected ones, keeping any fragment.
+func fixFile(path, contentType string, fixes map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fix := func(ref string) string {
+		link, fragment, hasFragment := strings.Cut(ref, "#")
+		fixed, ok := fixes[link]
+		if !ok {
+			return ref
+		}
+		if hasFragment {
+			fixed += "#" + fragment
+		}
+		return fixed
+	}
+
+	var out strings.Builder
+	if isCSSType(contentType) {
+		out.WriteString(rewriteCSS(string(data), fix))
+	} else {
+		doc, err := html.Parse(strings.NewReader(string(data)))
+		if err != nil {
+			return err
+		}
+		eachLink(doc, func(ref string, _ bool) string { return fix(ref) })
+		if err := html.Render(&out, doc); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(out.String()), 0644)
+}
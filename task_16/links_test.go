@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRewriteLinksRelativeToPage(t *testing.T) {
+	cases := []struct {
+		page string
+		ref  string
+		want string
+	}{
+		{"https://example.com/docs/guide/intro.html", "../api/ref.html#x", "../api/ref.html#x"},
+		{"https://example.com/docs/guide/intro.html", "/", "../../index.html"},
+		{"https://example.com/docs/guide/intro.html", "img/fig.png", "img/fig.png"},
+		{"https://example.com/docs/guide/intro.html", "/about", "../../about.html"},
+		{"https://example.com/docs/guide/intro.html", "/search?q=a b", "../../search@q=a%2520b.html"},
+		{"https://example.com/docs/guide/intro.html", "https://other.com/x", "https://other.com/x"},
+		{"https://example.com/docs/guide/intro.html", "#top", "#top"},
+		{"https://example.com/", "docs/my file.pdf", "docs/my%20file.pdf"},
+	}
+	for _, c := range cases {
+		doc, err := html.Parse(strings.NewReader(`<a href="` + c.ref + `">x</a>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _ := NewDownloader("https://example.com", "mirror", 1, 1)
+		d.rewriteLinks(doc, c.page, d.urlToFilePath(c.page, true))
+		if got := allLinks(doc); len(got) != 1 || got[0] != c.want {
+			t.Errorf("%s on %s: rewritten to %q; want %q", c.ref, c.page, got, c.want)
+		}
+	}
+
+	doc, _ := html.Parse(strings.NewReader(`<head><base href="/docs/"></head><a href="api.html">x</a>`))
+	d, _ := NewDownloader("https://example.com", "mirror", 1, 1)
+	d.rewriteLinks(doc, "https://example.com/other/page.html", "mirror/example.com/other/page.html")
+	if got := allLinks(doc); len(got) != 2 || got[1] != "../docs/api.html" {
+		t.Errorf("with <base>: %q", got)
+	}
+}
+
+func allLinks(doc *html.Node) []string {
+	var links []string
+	eachLink(doc, func(ref string, _ bool) string {
+		links = append(links, ref)
+		return ref
+	})
+	return links
+}
+
+func TestMirrorLinksMatchSavedFiles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/", "/docs/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/data#top">data</a><a href="/page?id=1">1</a><a href="/page?id=2">2</a>`)
+		case "/data":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{}`)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `page %s <a href="docs/">docs</a>`, r.URL.Query().Get("id"))
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	d, _ := NewDownloader(srv.URL, root, 3, 8)
+	d.IgnoreRobots = true
+	d.Start()
+	if len(d.Errors) != 0 {
+		t.Fatalf("errors %v", d.Errors)
+	}
+
+	site := filepath.Join(root, "127.0.0.1")
+	files := map[string][]string{
+		"index.html":      {`href="data#top"`, `href="page@id=1.html"`, `href="page@id=2.html"`},
+		"docs/index.html": {`href="../data#top"`, `href="../page@id=1.html"`},
+		"page@id=1.html":  {`page 1`, `href="docs/index.html"`},
+		"page@id=2.html":  {`page 2`},
+		"data":            {`{}`},
+	}
+	for name, wants := range files {
+		data, err := os.ReadFile(filepath.Join(site, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for _, want := range wants {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("%s does not contain %s:\n%s", name, want, data)
+			}
+		}
+	}
+}
+
+func TestFixLinksInExtensionlessStylesheet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<link rel="stylesheet" href="/theme">`)
+		case "/theme":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body{background:url(/bg)}`)
+		case "/bg":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "png")
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	d, _ := NewDownloader(srv.URL, root, 2, 8)
+	d.IgnoreRobots = true
+	d.Start()
+	if len(d.Errors) != 0 {
+		t.Fatalf("errors %v", d.Errors)
+	}
+
+	site := filepath.Join(root, "127.0.0.1")
+	if data, err := os.ReadFile(filepath.Join(site, "theme")); err != nil || string(data) != "body{background:url(bg)}" {
+		t.Errorf("theme = %q, %v; want body{background:url(bg)}", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(site, "index.html")); err != nil || !strings.Contains(string(data), `href="theme"`) {
+		t.Errorf("index.html = %q, %v", data, err)
+	}
+}
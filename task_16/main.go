@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/html"
@@ -26,6 +30,85 @@ type Downloader struct {
 	Errors    []error
 	ErrMu     sync.Mutex
 	wg        sync.WaitGroup
+
+	// UserAgent is sent with every request and picks the robots.txt group
+	// to obey. IgnoreRobots skips robots.txt and its Crawl-delay, for
+	// sites we run ourselves.
+	UserAgent    string
+	IgnoreRobots bool
+	// HostDelay is the least time between the starts of two requests to
+	// one host, and HostParallel the most requests to it at once.
+	HostDelay    time.Duration
+	HostParallel int
+
+	hosts  map[string]*hostState
+	hostMu sync.Mutex
+	state  *mirrorState
+
+	// guesses maps each saved file to the links in it made before their
+	// targets were downloaded.
+	guesses map[string]*guessedLinks
+	guessMu sync.Mutex
+
+	// The filters on what is downloaded, as in wget. SpanHosts follows
+	// pages on other hosts than BaseURL's, only those in Domains if it is
+	// set. Accept and Reject hold glob patterns and the regexps are
+	// matched against the URL path. MaxFileSize is in bytes; 0 means no
+	// limit.
+	SpanHosts      bool
+	Domains        []string
+	ExcludeDomains []string
+	IncludeDirs    []string
+	ExcludeDirs    []string
+	Accept         []string
+	Reject         []string
+	AcceptRegex    *regexp.Regexp
+	RejectRegex    *regexp.Regexp
+	NoParent       bool
+	MaxFileSize    int64
+
+	// Retries is how many times a request failing with a network error,
+	// 429 or 5xx is retried, waiting from RetryWait up to MaxRetryWait.
+	Retries      int
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration
+	// RequestsPerSecond and BytesPerSecond limit the whole crawl; 0 means
+	// no limit.
+	RequestsPerSecond float64
+	BytesPerSecond    int64
+
+	requests  pacer
+	bandwidth pacer
+
+	// Output stores what is downloaded; by default the tree under RootDir.
+	Output Output
+
+	// MaxPages limits how many pages are downloaded, not counting their
+	// requisites; 0 means no limit. UseSitemaps seeds the frontier from
+	// the site's sitemaps.
+	MaxPages    int
+	UseSitemaps bool
+
+	frontier *frontier
+	pages    int
+
+	// Progress, if set, is where a status line is kept up to date during
+	// the crawl, usually a terminal.
+	Progress io.Writer
+	progress progress
+
+	// Headers are added to the requests to BaseURL's origin, along with
+	// Basic auth for Username and Password or, if set, BearerToken; other
+	// hosts never see them. Cookies come from CookieFile, in the Netscape
+	// cookies.txt format, and from posting LoginForm to LoginURL before
+	// the crawl.
+	Headers     http.Header
+	Username    string
+	Password    string
+	BearerToken string
+	CookieFile  string
+	LoginURL    string
+	LoginForm   url.Values
 }
 
 func NewDownloader(rawurl, rootDir string, maxDepth, parallel int) (*Downloader, error) {
@@ -47,6 +130,15 @@ func NewDownloader(rawurl, rootDir string, maxDepth, parallel int) (*Downloader,
 		Visited:   make(map[string]struct{}),
 		Semaphore: make(chan struct{}, parallel),
 		Errors:    []error{},
+
+		UserAgent:    "wgetmirror/1.0",
+		HostParallel: 2,
+		state:        newMirrorState(),
+		Retries:      3,
+		RetryWait:    time.Second,
+		MaxRetryWait: 30 * time.Second,
+		Output:       dirOutput{},
+		frontier:     newFrontier(),
 	}, nil
 }
 
@@ -57,11 +149,54 @@ func (d *Downloader) appendError(err error) {
 }
 
 func (d *Downloader) Start() {
+	d.progress.start = time.Now()
+	if err := d.setupClient(); err != nil {
+		d.appendError(fmt.Errorf("error setting up client: %v", err))
+		return
+	}
+	if err := d.loadState(); err != nil {
+		d.appendError(fmt.Errorf("error loading state: %v", err))
+	}
+	d.state.mu.Lock()
+	frontier := make(map[string]int, len(d.state.Frontier))
+	for u, depth := range d.state.Frontier {
+		frontier[u] = depth
+	}
+	d.state.mu.Unlock()
+
 	d.download(d.BaseURL.String(), 0)
+	for u, depth := range frontier {
+		d.download(u, depth)
+	}
+	if d.UseSitemaps {
+		d.seedSitemaps()
+	}
+
+	if d.Progress != nil {
+		defer d.showProgress(200 * time.Millisecond)()
+	}
+	for range cap(d.Semaphore) {
+		d.wg.Add(1)
+		go d.worker()
+	}
 	d.wg.Wait()
+	d.progress.mu.Lock()
+	d.progress.end = time.Now()
+	d.progress.mu.Unlock()
+	d.fixLinks()
+	if err := d.saveState(); err != nil {
+		d.appendError(fmt.Errorf("error saving state: %v", err))
+	}
 }
 
+// download queues rawurl, found depth links away from BaseURL.
 func (d *Downloader) download(rawurl string, depth int) {
+	d.enqueue(rawurl, depth, defaultPriority, false)
+}
+
+// enqueue adds rawurl to the frontier unless it is too deep, has been
+// seen before or, not being a requisite, would go over MaxPages.
+func (d *Downloader) enqueue(rawurl string, depth int, priority float64, requisite bool) {
 	if depth > d.MaxDepth {
 		return
 	}
@@ -76,76 +211,273 @@ func (d *Downloader) download(rawurl string, depth int) {
 		d.Mu.Unlock()
 		return
 	}
+	if !requisite && d.MaxPages > 0 && d.pages >= d.MaxPages {
+		d.Mu.Unlock()
+		return
+	}
 	d.Visited[normalized] = struct{}{}
+	if !requisite {
+		d.pages++
+	}
 	d.Mu.Unlock()
+	d.state.queue(normalized, depth)
+	d.frontier.push(frontierItem{url: normalized, depth: depth, priority: priority, requisite: requisite})
+}
 
-	d.wg.Add(1)
-	d.Semaphore <- struct{}{}
-
-	go func(url string, currentDepth int) {
-		defer d.wg.Done()
-		defer func() { <-d.Semaphore }()
-
-		fmt.Printf("Downloading %s (depth %d)\n", url, currentDepth)
-		resp, err := d.Client.Get(url)
-		if err != nil {
-			d.appendError(fmt.Errorf("error fetching %s: %v", url, err))
+// worker downloads URLs from the frontier, queueing the links it finds,
+// until the frontier is exhausted.
+func (d *Downloader) worker() {
+	defer d.wg.Done()
+	for {
+		item, ok := d.frontier.pop()
+		if !ok {
 			return
 		}
-		defer resp.Body.Close()
+		d.Semaphore <- struct{}{}
+		links := d.fetch(item.url, item.depth)
+		<-d.Semaphore
+
+		for _, link := range links {
+			normalizedLink, err := d.normalizeURL(link.url)
+			if err != nil || normalizedLink == "" {
+				continue
+			}
+			if !d.wanted(normalizedLink, link.requisite) {
+				continue
+			}
+			// Requisites come with their page, even at the maximum depth.
+			if link.requisite {
+				d.enqueue(normalizedLink, item.depth, item.priority, true)
+			} else {
+				d.enqueue(normalizedLink, item.depth+1, defaultPriority, false)
+			}
+		}
+		d.state.done(item.url)
+		d.checkpoint()
+		d.frontier.finish()
+	}
+}
 
-		if resp.StatusCode != http.StatusOK {
-			d.appendError(fmt.Errorf("bad status for %s: %s", url, resp.Status))
-			return
+// fetch downloads rawurl into RootDir, holding one of its host's slots
+// while it does, and returns the links found if it is an HTML page or a
+// stylesheet. What an earlier run saved is revalidated, and a partial
+// download resumed.
+func (d *Downloader) fetch(rawurl string, depth int) []crawlLink {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil
+	}
+	if !d.allowed(rawurl) {
+		d.logf("Skipping %s (disallowed by robots.txt)", rawurl)
+		return nil
+	}
+	defer d.waitHost(u)()
+
+	res := fetchResult{URL: rawurl}
+	d.progress.begin()
+	defer func() { d.progress.finish(res) }()
+	fail := func(err error) []crawlLink {
+		res.Failed = true
+		d.appendError(err)
+		return nil
+	}
+
+	req, err := d.newRequest(rawurl)
+	if err != nil {
+		return fail(fmt.Errorf("error fetching %s: %v", rawurl, err))
+	}
+	prev := d.state.get(rawurl)
+	prevPath := d.urlToFilePath(rawurl, isHTMLType(prev.ContentType))
+	var offset int64
+	if prev.Complete && fileExists(prevPath) {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
 		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	} else if fi, err := os.Stat(prevPath + ".part"); err == nil && fi.Size() > 0 &&
+		prev.validator() != "" && !isParsedType(prev.ContentType) {
+		offset = fi.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", prev.validator())
+	}
+
+	d.logf("Downloading %s (depth %d)", rawurl, depth)
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+	resp, err := d.do(req)
+	if err != nil {
+		return fail(fmt.Errorf("error fetching %s: %v", rawurl, err))
+	}
+	defer resp.Body.Close()
+	res.Status = resp.StatusCode
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && prev.Complete:
+		d.logf("Not modified %s", rawurl)
+		res.ContentType = prev.ContentType
+		return prev.crawlLinks()
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp) == offset:
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	default:
+		return fail(fmt.Errorf("bad status for %s: %s", rawurl, resp.Status))
+	}
+
+	if d.MaxFileSize > 0 && offset+resp.ContentLength > d.MaxFileSize {
+		d.skipTooLarge(rawurl)
+		return nil
+	}
+	var body io.Reader = &countingReader{r: resp.Body, p: &d.progress}
+	if d.BytesPerSecond > 0 {
+		body = &throttledReader{r: body, d: d}
+	}
+	if d.MaxFileSize > 0 {
+		body = &sizeLimitReader{r: body, n: d.MaxFileSize - offset}
+	}
 
-		contentType := resp.Header.Get("Content-Type")
-		isHTML := strings.Contains(contentType, "text/html")
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode == http.StatusPartialContent {
+		contentType = prev.ContentType
+	}
+	isHTML := isHTMLType(contentType)
+	res.ContentType = contentType
+	entry := urlState{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         offset + resp.ContentLength,
+		ContentType:  contentType,
+	}
+	if resp.ContentLength < 0 {
+		entry.Size = -1
+	}
+	d.state.set(rawurl, entry)
+	if !isParsedType(contentType) && offset == 0 && (entry.Size < 0 || entry.Size >= resumableSize) {
+		// Save the validator now so that a killed run can resume the file.
+		d.saveState()
+	}
 
-		localPath := d.urlToFilePath(url, isHTML)
+	localPath := d.urlToFilePath(rawurl, isHTML)
 
-		err = os.MkdirAll(filepath.Dir(localPath), 0755)
-		if err != nil {
-			d.appendError(fmt.Errorf("error creating dir for %s: %v", localPath, err))
-			return
+	out, err := d.Output.Create(resp, localPath, offset)
+	if err != nil {
+		return fail(fmt.Errorf("error creating file %s: %v", localPath, err))
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			out.Abort()
 		}
+	}()
+	body = io.TeeReader(body, out.Raw())
+	content := out.Content()
 
-		f, err := os.Create(localPath)
+	tooLarge := func(err error) bool {
+		if !errors.Is(err, errTooLarge) {
+			return false
+		}
+		os.Remove(localPath + ".part")
+		d.skipTooLarge(rawurl)
+		return true
+	}
+
+	var links []crawlLink
+	if isHTML {
+		doc, err := html.Parse(body)
+		if tooLarge(err) {
+			return nil
+		}
 		if err != nil {
-			d.appendError(fmt.Errorf("error creating file %s: %v", localPath, err))
-			return
+			return fail(fmt.Errorf("html parse error %s: %v", rawurl, err))
 		}
-		defer f.Close()
-
-		if isHTML {
-			doc, err := html.Parse(resp.Body)
-			if err != nil {
-				d.appendError(fmt.Errorf("html parse error %s: %v", url, err))
-				io.Copy(f, resp.Body)
-				return
-			}
-			d.rewriteLinks(doc)
-			html.Render(f, doc)
-			f.Sync()
-
-			links := d.collectLinks(doc)
-			for _, link := range links {
-				normalizedLink, err := d.normalizeURL(link)
-				if err != nil || normalizedLink == "" {
-					continue
-				}
-				if d.isInDomain(normalizedLink) {
-					d.download(normalizedLink, currentDepth+1)
-				}
-			}
-		} else {
-			_, err = io.Copy(f, resp.Body)
-			if err != nil {
-				d.appendError(fmt.Errorf("error saving resource %s: %v", url, err))
-				return
+		base := documentBase(doc, u)
+		eachLink(doc, func(ref string, requisite bool) string {
+			if absurl, ok := resolveRef(base, ref); ok {
+				links = append(links, crawlLink{url: absurl, requisite: requisite})
 			}
+			return ref
+		})
+		d.rewriteLinks(doc, rawurl, localPath)
+		if err := html.Render(content, doc); err != nil {
+			return fail(fmt.Errorf("error saving resource %s: %v", rawurl, err))
+		}
+	} else if isCSSType(contentType) {
+		css, err := io.ReadAll(body)
+		if tooLarge(err) {
+			return nil
+		}
+		if err != nil {
+			return fail(fmt.Errorf("error saving resource %s: %v", rawurl, err))
+		}
+		var rewritten string
+		links, rewritten = d.stylesheetLinks(rawurl, string(css), localPath)
+		if _, err := io.WriteString(content, rewritten); err != nil {
+			return fail(fmt.Errorf("error saving resource %s: %v", rawurl, err))
+		}
+		entry.Size = int64(len(css))
+	} else {
+		n, err := io.Copy(content, body)
+		if tooLarge(err) {
+			return nil
 		}
-	}(normalized, depth)
+		if err != nil {
+			return fail(fmt.Errorf("error saving resource %s: %v", rawurl, err))
+		}
+		entry.Size = offset + n
+	}
+	committed = true
+	if err := out.Commit(); err != nil {
+		return fail(fmt.Errorf("error saving resource %s: %v", rawurl, err))
+	}
+	entry.Complete = true
+	res.Size = entry.Size
+	entry.setLinks(links)
+	d.state.set(rawurl, entry)
+	return links
+}
+
+// newRequest makes a GET request for rawurl with our User-Agent.
+func (d *Downloader) newRequest(rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", d.UserAgent)
+	return req, nil
+}
+
+// resumableSize is the size from which a download's state is saved as
+// soon as it starts.
+const resumableSize = 1 << 20
+
+func isHTMLType(contentType string) bool {
+	return strings.Contains(contentType, "text/html")
+}
+
+func isCSSType(contentType string) bool {
+	return strings.Contains(contentType, "text/css")
+}
+
+// isParsedType reports whether we rewrite files of contentType, which
+// rules out resuming them part way.
+func isParsedType(contentType string) bool {
+	return isHTMLType(contentType) || isCSSType(contentType)
+}
+
+// contentRangeStart returns the first byte offset of a 206 response, or
+// -1 if its Content-Range cannot be read.
+func contentRangeStart(resp *http.Response) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d", &start, &end); err != nil {
+		return -1
+	}
+	return start
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
 }
 
 func (d *Downloader) normalizeURL(rawurl string) (string, error) {
@@ -199,63 +531,86 @@ func (d *Downloader) urlToFilePath(rawurl string, isHTML bool) string {
 	} else if isHTML && !strings.HasSuffix(path, ".html") && !strings.HasSuffix(path, ".htm") {
 		path += ".html"
 	}
+	if u.RawQuery != "" {
+		dir, name := filepath.Split(path)
+		path = filepath.Join(dir, queryFileName(name, u.RawQuery))
+	}
 	localPath := filepath.Join(d.RootDir, u.Hostname(), path)
 	return localPath
 }
 
 func (d *Downloader) collectLinks(n *html.Node) []string {
 	var links []string
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			for _, attr := range n.Attr {
-				if (attr.Key == "href" || attr.Key == "src") && attr.Val != "" {
-					links = append(links, attr.Val)
-				}
-			}
+	eachLink(n, func(ref string, _ bool) string {
+		if fetchableRef(ref) {
+			links = append(links, ref)
 		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
-		}
-	}
-	f(n)
+		return ref
+	})
 	return links
 }
 
-func (d *Downloader) rewriteLinks(n *html.Node) {
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			for i, attr := range n.Attr {
-				if (attr.Key == "href" || attr.Key == "src") && attr.Val != "" {
-					absurl, err := d.normalizeURL(attr.Val)
-					if err == nil && d.isInDomain(absurl) {
-						localPath := d.urlToFilePath(absurl, strings.HasSuffix(absurl, ".html") || strings.HasSuffix(absurl, "/"))
-						relPath, err := filepath.Rel(d.RootDir, localPath)
-						if err == nil {
-							n.Attr[i].Val = relPath
-						} else {
-							n.Attr[i].Val = localPath
-						}
-					}
-				}
-			}
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
-		}
+// rewriteLinks points the links in a page fetched from pageURL and saved
+// at localPath to our own site at the files in the mirror, relative to
+// the page.
+func (d *Downloader) rewriteLinks(n *html.Node, pageURL, localPath string) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return
 	}
-	f(n)
+	base := documentBase(n, u)
+	eachLink(n, func(ref string, requisite bool) string {
+		return d.localRef(pageURL, localPath, base, ref, requisite)
+	})
 }
 
 func main() {
 	depth := flag.Int("d", 1, "depth for recursive downloads")
 	parallel := flag.Int("n", 3, "number of parallel downloads")
+	hostParallel := flag.Int("host-n", 2, "number of parallel downloads from one host")
+	wait := flag.Duration("wait", 0, "minimum time between requests to one host")
+	userAgent := flag.String("user-agent", "wgetmirror/1.0", "User-Agent header, also used to match robots.txt")
+	noRobots := flag.Bool("no-robots", false, "ignore robots.txt and Crawl-delay (for sites you run)")
+	spanHosts := flag.Bool("span-hosts", false, "follow links to other hosts")
+	domains := flag.String("domains", "", "comma-separated domains to follow with -span-hosts")
+	excludeDomains := flag.String("exclude-domains", "", "comma-separated domains never to download from")
+	includeDirs := flag.String("include-directories", "", "comma-separated directories to follow (globs allowed)")
+	excludeDirs := flag.String("exclude-directories", "", "comma-separated directories not to follow (globs allowed)")
+	accept := flag.String("accept", "", "comma-separated file name globs to download")
+	reject := flag.String("reject", "", "comma-separated file name globs not to download")
+	acceptRegex := flag.String("accept-regex", "", "regexp the URL path of downloaded files must match")
+	rejectRegex := flag.String("reject-regex", "", "regexp the URL path of downloaded files must not match")
+	noParent := flag.Bool("no-parent", false, "do not ascend above the starting directory")
+	maxSize := flag.Int64("max-size", 0, "skip files larger than this many bytes (0 for no limit)")
+	retries := flag.Int("tries", 3, "number of retries of a failed request")
+	retryWait := flag.Duration("waitretry", time.Second, "wait before the first retry, doubling on each")
+	rate := flag.Float64("rate", 0, "maximum requests per second (0 for no limit)")
+	limitRate := flag.Int64("limit-rate", 0, "maximum download speed in bytes per second (0 for no limit)")
+	maxPages := flag.Int("max-pages", 0, "maximum number of pages to download (0 for no limit)")
+	sitemaps := flag.Bool("sitemaps", false, "seed the crawl from the site's sitemaps")
+	headers := headerFlag{}
+	flag.Var(headers, "header", "add a header, as \"Name: value\", to requests to the site (repeatable)")
+	httpUser := flag.String("http-user", "", "user name for HTTP Basic auth")
+	httpPassword := flag.String("http-password", "", "password for HTTP Basic auth")
+	bearer := flag.String("bearer", "", "token for HTTP Bearer auth")
+	loadCookies := flag.String("load-cookies", "", "load cookies from a Netscape cookies.txt file")
+	loginURL := flag.String("login-url", "", "post -login-data to this URL to log in before crawling")
+	loginData := flag.String("login-data", "", "form data to post to -login-url, as name=value&name=value")
+	showProgress := flag.Bool("progress", isTerminal(os.Stderr), "show a status line on stderr during the crawl")
+	reportJSON := flag.String("report-json", "", "write the crawl summary to this file as JSON")
+	warcFile := flag.String("warc-file", "", "also write the crawl to WARC files starting with this name")
+	warcMaxSize := flag.Int64("warc-max-size", 1<<30, "start a new WARC file after this many bytes")
 	flag.Parse()
 
+	loginForm, err := url.ParseQuery(*loginData)
+	if err != nil {
+		fmt.Printf("Invalid login data: %v\n", err)
+		os.Exit(1)
+	}
+
 	args := flag.Args()
 	if len(args) < 1 {
-		fmt.Println("Usage: wgetmirror [-d depth] [-n parallel] URL")
+		fmt.Println("Usage: wgetmirror [-d depth] [-n parallel] [-host-n parallel] [-wait delay] [-user-agent agent] [-no-robots] [filters] URL")
 		os.Exit(1)
 	}
 	url := args[0]
@@ -270,11 +625,73 @@ func main() {
 		fmt.Printf("Error initializing downloader: %v\n", err)
 		os.Exit(1)
 	}
+	downloader.HostParallel = *hostParallel
+	downloader.HostDelay = *wait
+	downloader.UserAgent = *userAgent
+	downloader.IgnoreRobots = *noRobots
+	downloader.SpanHosts = *spanHosts
+	downloader.Domains = splitList(*domains)
+	downloader.ExcludeDomains = splitList(*excludeDomains)
+	downloader.IncludeDirs = splitList(*includeDirs)
+	downloader.ExcludeDirs = splitList(*excludeDirs)
+	downloader.Accept = splitList(*accept)
+	downloader.Reject = splitList(*reject)
+	downloader.NoParent = *noParent
+	downloader.MaxFileSize = *maxSize
+	downloader.Retries = *retries
+	downloader.RetryWait = *retryWait
+	downloader.RequestsPerSecond = *rate
+	downloader.BytesPerSecond = *limitRate
+	downloader.MaxPages = *maxPages
+	downloader.UseSitemaps = *sitemaps
+	if *showProgress {
+		downloader.Progress = os.Stderr
+	}
+	downloader.Headers = http.Header(headers)
+	downloader.Username = *httpUser
+	downloader.Password = *httpPassword
+	downloader.BearerToken = *bearer
+	downloader.CookieFile = *loadCookies
+	downloader.LoginURL = *loginURL
+	downloader.LoginForm = loginForm
+	var warc *WARCWriter
+	if *warcFile != "" {
+		warc = NewWARCWriter(*warcFile, *warcMaxSize)
+		warc.Description = "mirror of " + url
+		for name := range downloader.Headers {
+			warc.Redact = append(warc.Redact, name)
+		}
+		downloader.Output = MultiOutput{dirOutput{}, warc}
+	}
+	downloader.AcceptRegex = compileFlag(*acceptRegex)
+	downloader.RejectRegex = compileFlag(*rejectRegex)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		downloader.saveState()
+		fmt.Fprintln(os.Stderr, "Interrupted; run again to resume.")
+		os.Exit(130)
+	}()
 
 	downloader.Start()
+	if warc != nil {
+		if err := warc.Close(); err != nil {
+			downloader.appendError(fmt.Errorf("error closing WARC file: %v", err))
+		}
+	}
 
 	downloader.wg.Wait()
 
+	summary := downloader.Summary(10)
+	summary.WriteText(os.Stdout)
+	if *reportJSON != "" {
+		if err := summary.WriteJSONFile(*reportJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		}
+	}
+
 	if len(downloader.Errors) > 0 {
 		fmt.Fprintln(os.Stderr, "Download completed with errors:")
 		for _, e := range downloader.Errors {
@@ -284,3 +701,23 @@ func main() {
 	}
 	fmt.Println("Download complete.")
 }
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// compileFlag compiles a regexp given on the command line, or returns nil
+// if it is empty.
+func compileFlag(expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		fmt.Printf("Invalid regexp %q: %v\n", expr, err)
+		os.Exit(1)
+	}
+	return re
+}
@@ -88,6 +88,10 @@ func TestUrlToFilePath(t *testing.T) {
 		{"https://example.com/abc", true, "mirror/example.com/abc.html"},
 		{"https://example.com/img.png", false, "mirror/example.com/img.png"},
 		{"https://example.com/dir/", true, "mirror/example.com/dir/index.html"},
+		{"https://example.com/dir/?q=1", true, "mirror/example.com/dir/index@q=1.html"},
+		{"https://example.com/list.php?page=2", true, "mirror/example.com/list.php@page=2.html"},
+		{"https://example.com/img.png?v=1&p=a/b", false, "mirror/example.com/img@v=1&p=a%2Fb.png"},
+		{"https://example.com/s?" + strings.Repeat("x", 65), false, "mirror/example.com/s@9537c5fdf120482f"},
 	}
 
 	for _, c := range cases {
@@ -135,7 +139,7 @@ func TestRewriteLinks(t *testing.T) {
 		t.Fatalf("html.Parse error: %v", err)
 	}
 	d, _ := NewDownloader("https://example.com", "mirror", 1, 1)
-	d.rewriteLinks(doc)
+	d.rewriteLinks(doc, "https://example.com/", "mirror/example.com/index.html")
 
 	var urls []string
 	var f func(*html.Node)
@@ -162,7 +166,7 @@ func TestRewriteLinks(t *testing.T) {
 
 func TestDownloadRecursionAndErrorHandling(t *testing.T) {
 	base := "https://example.com/"
-	d, _ := NewDownloader(base, "mirror", 2, 2)
+	d, _ := NewDownloader(base, t.TempDir(), 2, 2)
 
 	responses := map[string]*http.Response{
 		"https://example.com/":        newHTTPResponse(`<a href="/page1">p1</a>`, 200, "text/html"),
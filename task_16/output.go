@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// An Output stores the files a crawl downloads.
+type Output interface {
+	// Create starts storing the response resp, whose content belongs at
+	// localPath in the mirror. If offset is not 0, resp carries the rest
+	// of a partial download of that many bytes.
+	Create(resp *http.Response, localPath string, offset int64) (OutputFile, error)
+}
+
+// An OutputFile receives one downloaded file: the body as it came over
+// the wire on Raw and the content to save, with its links rewritten, on
+// Content. Nothing is kept unless Commit succeeds.
+type OutputFile interface {
+	Raw() io.Writer
+	Content() io.Writer
+	Commit() error
+	// Abort gives up on the file. A partial download may be kept so that
+	// a later run can resume it.
+	Abort()
+}
+
+// dirOutput saves files in the local tree. A file is written next to its
+// final path with a .part suffix and renamed when it is complete.
+type dirOutput struct{}
+
+type dirFile struct {
+	f    *os.File
+	path string
+}
+
+func (dirOutput) Create(resp *http.Response, localPath string, offset int64) (OutputFile, error) {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(localPath+".part", flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{f: f, path: localPath}, nil
+}
+
+func (d *dirFile) Raw() io.Writer     { return io.Discard }
+func (d *dirFile) Content() io.Writer { return d.f }
+
+func (d *dirFile) Commit() error {
+	err := d.f.Sync()
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(d.f.Name(), d.path)
+}
+
+func (d *dirFile) Abort() {
+	d.f.Close()
+}
+
+// MultiOutput stores every file in all of its outputs.
+type MultiOutput []Output
+
+type multiFile []OutputFile
+
+func (m MultiOutput) Create(resp *http.Response, localPath string, offset int64) (OutputFile, error) {
+	var files multiFile
+	for _, out := range m {
+		f, err := out.Create(resp, localPath, offset)
+		if err != nil {
+			files.Abort()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func (m multiFile) Raw() io.Writer {
+	var ws []io.Writer
+	for _, f := range m {
+		ws = append(ws, f.Raw())
+	}
+	return io.MultiWriter(ws...)
+}
+
+func (m multiFile) Content() io.Writer {
+	var ws []io.Writer
+	for _, f := range m {
+		ws = append(ws, f.Content())
+	}
+	return io.MultiWriter(ws...)
+}
+
+func (m multiFile) Commit() error {
+	var errs []error
+	for _, f := range m {
+		errs = append(errs, f.Commit())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiFile) Abort() {
+	for _, f := range m {
+		f.Abort()
+	}
+}
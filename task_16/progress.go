@@ -0,0 +1,281 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fetchResult is the outcome of one fetch, for the summary report.
+type fetchResult struct {
+	URL         string
+	Status      int
+	ContentType string
+	Size        int64
+	Duration    time.Duration
+	Failed      bool
+}
+
+// progress counts what the crawl has done so far. While a status line is
+// shown on out, everything logged clears it first; the next tick redraws it.
+type progress struct {
+	mu       sync.Mutex
+	start    time.Time
+	end      time.Time
+	inFlight int
+	done     int
+	failed   int
+	results  []fetchResult
+	out      io.Writer
+	shown    bool
+
+	bytes atomic.Int64
+}
+
+func (p *progress) begin() {
+	p.mu.Lock()
+	p.inFlight++
+	p.mu.Unlock()
+}
+
+func (p *progress) finish(res fetchResult) {
+	p.mu.Lock()
+	p.inFlight--
+	if res.Failed {
+		p.failed++
+	} else {
+		p.done++
+	}
+	p.results = append(p.results, res)
+	p.mu.Unlock()
+}
+
+// clear erases the status line, if one is shown. p.mu must be held.
+func (p *progress) clear() {
+	if p.shown {
+		fmt.Fprint(p.out, "\r\x1b[K")
+		p.shown = false
+	}
+}
+
+// elapsed is how long the crawl has been running, or ran for.
+func (p *progress) elapsed() time.Duration {
+	if !p.end.IsZero() {
+		return p.end.Sub(p.start)
+	}
+	return time.Since(p.start)
+}
+
+// countingReader adds what is read through it to the bytes transferred.
+type countingReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.p.bytes.Add(int64(n))
+	return n, err
+}
+
+// logf prints a line of the crawl's log, above the status line.
+func (d *Downloader) logf(format string, args ...any) {
+	d.progress.mu.Lock()
+	defer d.progress.mu.Unlock()
+	d.progress.clear()
+	fmt.Printf(format+"\n", args...)
+}
+
+// showProgress redraws the status line on Progress every interval until
+// the returned function is called.
+func (d *Downloader) showProgress(interval time.Duration) func() {
+	p := &d.progress
+	p.mu.Lock()
+	p.out = d.Progress
+	p.mu.Unlock()
+	draw := func() {
+		queued := d.frontier.len()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		bytes := p.bytes.Load()
+		rate := float64(bytes) / max(p.elapsed().Seconds(), 1e-3)
+		fmt.Fprintf(p.out, "\r\x1b[Kqueued %d  in flight %d  done %d  failed %d  %s  %s/s",
+			queued, p.inFlight, p.done, p.failed, formatBytes(bytes), formatBytes(int64(rate)))
+		p.shown = true
+	}
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				draw()
+			case <-stop:
+				draw()
+				p.mu.Lock()
+				fmt.Fprintln(p.out)
+				p.shown = false
+				p.mu.Unlock()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// formatBytes formats n with a binary unit.
+func formatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", v, units[i])
+}
+
+// Summary reports on a finished crawl.
+type Summary struct {
+	Done         int            `json:"done"`
+	Failed       int            `json:"failed"`
+	Bytes        int64          `json:"bytes"`
+	Seconds      float64        `json:"seconds"`
+	StatusCodes  map[int]int    `json:"status_codes"`
+	ContentTypes map[string]int `json:"content_types"`
+	Largest      []FileSummary  `json:"largest"`
+	Slowest      []FileSummary  `json:"slowest"`
+	Errors       []string       `json:"errors"`
+}
+
+// FileSummary describes one fetched URL.
+type FileSummary struct {
+	URL         string  `json:"url"`
+	Status      int     `json:"status,omitempty"`
+	ContentType string  `json:"content_type,omitempty"`
+	Size        int64   `json:"size"`
+	Seconds     float64 `json:"seconds"`
+}
+
+// Summary reports on the crawl, listing the n largest files and the n
+// slowest URLs.
+func (d *Downloader) Summary(n int) *Summary {
+	p := &d.progress
+	p.mu.Lock()
+	results := slices.Clone(p.results)
+	s := &Summary{
+		Done:         p.done,
+		Failed:       p.failed,
+		Bytes:        p.bytes.Load(),
+		Seconds:      p.elapsed().Seconds(),
+		StatusCodes:  make(map[int]int),
+		ContentTypes: make(map[string]int),
+		Errors:       []string{},
+	}
+	p.mu.Unlock()
+
+	for _, res := range results {
+		if res.Status != 0 {
+			s.StatusCodes[res.Status]++
+		}
+		if mediaType := mediaType(res.ContentType); mediaType != "" && !res.Failed {
+			s.ContentTypes[mediaType]++
+		}
+	}
+	top := func(keep func(fetchResult) bool, compare func(a, b fetchResult) int) []FileSummary {
+		var files []FileSummary
+		for _, res := range slices.SortedStableFunc(slices.Values(results), compare) {
+			if len(files) == n {
+				break
+			}
+			if keep(res) {
+				files = append(files, FileSummary{
+					URL:         res.URL,
+					Status:      res.Status,
+					ContentType: mediaType(res.ContentType),
+					Size:        res.Size,
+					Seconds:     res.Duration.Seconds(),
+				})
+			}
+		}
+		return files
+	}
+	s.Largest = top(func(res fetchResult) bool { return res.Size > 0 },
+		func(a, b fetchResult) int { return cmp.Compare(b.Size, a.Size) })
+	s.Slowest = top(func(res fetchResult) bool { return res.Duration > 0 },
+		func(a, b fetchResult) int { return cmp.Compare(b.Duration, a.Duration) })
+
+	d.ErrMu.Lock()
+	for _, err := range d.Errors {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	d.ErrMu.Unlock()
+	return s
+}
+
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// WriteText writes the summary for people to read.
+func (s *Summary) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Fetched %d URLs (%d failed), %s in %.1fs\n", s.Done+s.Failed, s.Failed, formatBytes(s.Bytes), s.Seconds)
+	writeCounts(w, "Status codes", s.StatusCodes, strconv.Itoa)
+	writeCounts(w, "Content types", s.ContentTypes, func(t string) string { return t })
+	if len(s.Largest) > 0 {
+		fmt.Fprintln(w, "Largest files:")
+		for _, f := range s.Largest {
+			fmt.Fprintf(w, "  %10s  %s\n", formatBytes(f.Size), f.URL)
+		}
+	}
+	if len(s.Slowest) > 0 {
+		fmt.Fprintln(w, "Slowest URLs:")
+		for _, f := range s.Slowest {
+			fmt.Fprintf(w, "  %9.2fs  %s\n", f.Seconds, f.URL)
+		}
+	}
+}
+
+// writeCounts lists counts, the most common first.
+func writeCounts[K cmp.Ordered](w io.Writer, title string, counts map[K]int, format func(K) string) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]K, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %6d  %s\n", counts[k], format(k))
+	}
+}
+
+// WriteJSONFile writes the summary to path as JSON.
+func (s *Summary) WriteJSONFile(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{0: "0 B", 1023: "1023 B", 1024: "1.0 KiB", 1536: "1.5 KiB", 5 << 20: "5.0 MiB", 3 << 30: "3.0 GiB"}
+	for n, want := range cases {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q; want %q", n, got, want)
+		}
+	}
+}
+
+func TestProgressAndSummary(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<a href="/big.bin">big</a><a href="/slow.txt">slow</a><a href="/missing">missing</a><link rel="stylesheet" href="/s.css">`)
+		case "/big.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, strings.Repeat("x", 5000))
+		case "/slow.txt":
+			time.Sleep(300 * time.Millisecond)
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "slow")
+		case "/s.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, "body{}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var display bytes.Buffer
+	d, _ := NewDownloader(srv.URL, t.TempDir(), 1, 4)
+	d.IgnoreRobots = true
+	d.Retries = 0
+	d.Progress = &display
+	d.Start()
+
+	if !strings.Contains(display.String(), "done 4  failed 1") || !strings.HasSuffix(display.String(), "\n") {
+		t.Errorf("progress display %q; want a final line with done 4  failed 1", display.String())
+	}
+
+	s := d.Summary(2)
+	if s.Done != 4 || s.Failed != 1 || s.Bytes < 5000 || len(s.Errors) != 1 {
+		t.Errorf("summary done %d, failed %d, bytes %d, errors %q; want 4, 1, >= 5000, 1", s.Done, s.Failed, s.Bytes, s.Errors)
+	}
+	if s.StatusCodes[200] != 4 || s.StatusCodes[404] != 1 {
+		t.Errorf("status codes %v; want 4 200s and a 404", s.StatusCodes)
+	}
+	wantTypes := map[string]int{"text/html": 1, "application/octet-stream": 1, "text/plain": 1, "text/css": 1}
+	if fmt.Sprint(s.ContentTypes) != fmt.Sprint(wantTypes) {
+		t.Errorf("content types %v; want %v", s.ContentTypes, wantTypes)
+	}
+	if len(s.Largest) != 2 || s.Largest[0].URL != srv.URL+"/big.bin" || s.Largest[1].URL != srv.URL+"/" {
+		t.Errorf("largest %+v; want big.bin then the index", s.Largest)
+	}
+	if len(s.Slowest) != 2 || s.Slowest[0].URL != srv.URL+"/slow.txt" {
+		t.Errorf("slowest %+v; want slow.txt first", s.Slowest)
+	}
+
+	var text bytes.Buffer
+	s.WriteText(&text)
+	for _, want := range []string{"Fetched 5 URLs (1 failed)", "     4  200\n", "Largest files:", "4.9 KiB  " + srv.URL + "/big.bin", "Slowest URLs:"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("summary text lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := s.WriteJSONFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	var got Summary
+	if err := json.Unmarshal(data, &got); err != nil || got.StatusCodes[404] != 1 || got.Largest[0].Size != 5000 {
+		t.Errorf("JSON report %s (error %v) does not round-trip", data, err)
+	}
+}
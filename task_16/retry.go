@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pacer spaces events out: each call to wait returns no sooner than the
+// interval given by the call before it.
+type pacer struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (p *pacer) wait(interval time.Duration) {
+	p.mu.Lock()
+	now := time.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	p.next = start.Add(interval)
+	p.mu.Unlock()
+	time.Sleep(start.Sub(now))
+}
+
+// do sends req, retrying network errors, 429 and 5xx responses up to
+// Retries times. The wait doubles from RetryWait up to MaxRetryWait, with
+// jitter, unless the response has a Retry-After; one longer than
+// MaxRetryWait is not waited for and the response is returned.
+func (d *Downloader) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if d.RequestsPerSecond > 0 {
+			d.requests.wait(time.Duration(float64(time.Second) / d.RequestsPerSecond))
+		}
+		// The client adds the jar's cookies to the request it is given, so
+		// each attempt sends a fresh copy.
+		resp, err := d.Client.Do(req.Clone(req.Context()))
+		if attempt >= d.Retries || !retryable(resp, err) {
+			return resp, err
+		}
+
+		wait := d.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				if after > d.MaxRetryWait {
+					return resp, nil
+				}
+				wait = after
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		d.logf("Retrying %s in %v (%s)", req.URL, wait.Round(time.Millisecond), reason)
+		time.Sleep(wait)
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// backoff returns how long to wait before retry attempt+1: RetryWait
+// doubled each attempt, at most MaxRetryWait, and then a random amount
+// between half and all of that.
+func (d *Downloader) backoff(attempt int) time.Duration {
+	wait := d.MaxRetryWait
+	if attempt < 30 && d.RetryWait<<attempt < d.MaxRetryWait {
+		wait = d.RetryWait << attempt
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// retryAfter parses a Retry-After header, in seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// throttledReader holds reads from r to BytesPerSecond over all the
+// downloads running at once.
+type throttledReader struct {
+	r io.Reader
+	d *Downloader
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read a tenth of a second's worth at a time so the pace stays even.
+	if chunk := max(int(t.d.BytesPerSecond/10), 1); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.d.bandwidth.wait(time.Duration(n) * time.Second / time.Duration(t.d.BytesPerSecond))
+	}
+	return n, err
+}
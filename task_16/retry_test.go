@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts[r.URL.Path]++
+		n := attempts[r.URL.Path]
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/flaky">1</a><a href="/limited">2</a><a href="/dead">3</a><a href="/dropped">4</a><a href="/missing">5</a>`)
+		case "/flaky":
+			if n <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		case "/limited":
+			if n == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		case "/dead":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/dropped":
+			if n == 1 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			fmt.Fprint(w, "ok")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	d, _ := NewDownloader(srv.URL, t.TempDir(), 1, 8)
+	d.IgnoreRobots = true
+	d.RetryWait = 10 * time.Millisecond
+	start := time.Now()
+	d.Start()
+	elapsed := time.Since(start)
+
+	want := map[string]int{"/": 1, "/flaky": 3, "/limited": 2, "/dead": 4, "/dropped": 2, "/missing": 1}
+	for path, n := range want {
+		if attempts[path] != n {
+			t.Errorf("%s requested %d times; want %d", path, attempts[path], n)
+		}
+	}
+	if len(d.Errors) != 2 || !strings.Contains(fmt.Sprint(d.Errors), "/dead: 500") || !strings.Contains(fmt.Sprint(d.Errors), "/missing: 404") {
+		t.Errorf("errors %v", d.Errors)
+	}
+	if elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("took %v; Retry-After: 1 not honoured", elapsed)
+	}
+}
+
+func TestRetryCookies(t *testing.T) {
+	var mu sync.Mutex
+	var cookies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		cookies = append(cookies, r.Header.Get("Cookie"))
+		n := len(cookies)
+		mu.Unlock()
+		if n <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	d, _ := NewDownloader(srv.URL+"/file.txt", t.TempDir(), 0, 1)
+	d.IgnoreRobots = true
+	d.RetryWait = time.Millisecond
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse(srv.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "x"}})
+	d.Client.Jar = jar
+	d.Start()
+
+	if len(d.Errors) != 0 || !slices.Equal(cookies, []string{"sid=x", "sid=x", "sid=x"}) {
+		t.Errorf("errors %v, Cookie headers %q", d.Errors, cookies)
+	}
+}
+
+func TestBackoffAndRetryAfter(t *testing.T) {
+	d, _ := NewDownloader("https://example.com", "mirror", 1, 1)
+	d.RetryWait, d.MaxRetryWait = 100*time.Millisecond, time.Second
+	for attempt, limit := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		limit *= time.Millisecond
+		if wait := d.backoff(attempt); wait < limit/2 || wait > limit {
+			t.Errorf("backoff(%d) = %v; want between %v and %v", attempt, wait, limit/2, limit)
+		}
+	}
+
+	if wait, ok := retryAfter("3"); !ok || wait != 3*time.Second {
+		t.Errorf("retryAfter(3) = %v, %v", wait, ok)
+	}
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if wait, ok := retryAfter(date); !ok || wait < 8*time.Second || wait > 10*time.Second {
+		t.Errorf("retryAfter(%q) = %v, %v", date, wait, ok)
+	}
+	if _, ok := retryAfter("soon"); ok {
+		t.Errorf("retryAfter(soon) ok")
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/c">c</a>`)
+			return
+		}
+		fmt.Fprint(w, strings.Repeat("x", 2000))
+	}))
+	defer srv.Close()
+
+	d, _ := NewDownloader(srv.URL, t.TempDir(), 1, 8)
+	d.IgnoreRobots = true
+	d.RequestsPerSecond = 20
+	start := time.Now()
+	d.Start()
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 requests at 20/s took %v", elapsed)
+	}
+
+	d, _ = NewDownloader(srv.URL, t.TempDir(), 1, 8)
+	d.IgnoreRobots = true
+	d.BytesPerSecond = 12000
+	start = time.Now()
+	d.Start()
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || len(d.Errors) != 0 {
+		t.Errorf("6000 bytes at 12000/s took %v, errors %v", elapsed, d.Errors)
+	}
+}
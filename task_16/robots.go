@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsRules holds the robots.txt group that applies to our user agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	// sitemaps lists the Sitemap URLs, which apply to every group.
+	sitemaps []string
+}
+
+type robotsRule struct {
+	pattern string
+	re      *regexp.Regexp
+	allow   bool
+}
+
+// parseRobots reads a robots.txt file and keeps the rules for the group
+// naming agent, or for the * group if no group names it.
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+	if i := strings.IndexByte(agent, '/'); i >= 0 {
+		agent = agent[:i]
+	}
+
+	var named, star *robotsRules
+	var current []*robotsRules
+	var sitemaps []string
+	inAgents := false
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			ua := strings.ToLower(value)
+			switch {
+			case ua == "*":
+				if star == nil {
+					star = &robotsRules{}
+				}
+				current = append(current, star)
+			case ua != "" && strings.Contains(agent, ua):
+				if named == nil {
+					named = &robotsRules{}
+				}
+				current = append(current, named)
+			}
+			continue
+		}
+		inAgents = false
+		if key == "sitemap" {
+			if value != "" {
+				sitemaps = append(sitemaps, value)
+			}
+			continue
+		}
+		for _, g := range current {
+			switch key {
+			case "allow", "disallow":
+				if value != "" {
+					g.rules = append(g.rules, robotsRule{pattern: value, re: robotsPattern(value), allow: key == "allow"})
+				}
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs >= 0 {
+					g.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+	rules := &robotsRules{}
+	if named != nil {
+		rules = named
+	} else if star != nil {
+		rules = star
+	}
+	rules.sitemaps = sitemaps
+	return rules
+}
+
+// allowed reports whether path may be fetched. The longest matching rule
+// wins and Allow wins a tie; a path no rule matches is allowed.
+func (r *robotsRules) allowed(path string) bool {
+	allow, best := true, -1
+	for _, rule := range r.rules {
+		if len(rule.pattern) < best || !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > best || rule.allow {
+			allow, best = rule.allow, len(rule.pattern)
+		}
+	}
+	return allow
+}
+
+// robotsPattern compiles a robots.txt path pattern, where * matches any
+// run of characters and a trailing $ anchors the end.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	parts := strings.Split(strings.TrimSuffix(pattern, "$"), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// hostState paces the requests made to one host.
+type hostState struct {
+	slots chan struct{}
+	pacer pacer
+
+	robotsOnce sync.Once
+	robots     *robotsRules
+}
+
+func (d *Downloader) host(u *url.URL) *hostState {
+	d.hostMu.Lock()
+	defer d.hostMu.Unlock()
+	if d.hosts == nil {
+		d.hosts = make(map[string]*hostState)
+	}
+	key := u.Scheme + "://" + u.Host
+	h, ok := d.hosts[key]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, max(d.HostParallel, 1))}
+		d.hosts[key] = h
+	}
+	return h
+}
+
+// robotsFor fetches and parses the robots.txt of u's host once. A missing
+// or unreadable robots.txt allows everything.
+func (d *Downloader) robotsFor(u *url.URL) *robotsRules {
+	h := d.host(u)
+	h.robotsOnce.Do(func() {
+		h.robots = &robotsRules{}
+		if d.IgnoreRobots {
+			return
+		}
+		robotsURL := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+		req, err := d.newRequest(robotsURL.String())
+		if err != nil {
+			return
+		}
+		// robots.txt is optional, so a failure is not worth retrying.
+		resp, err := d.Client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			h.robots = parseRobots(io.LimitReader(resp.Body, 512<<10), d.UserAgent)
+		}
+	})
+	return h.robots
+}
+
+// allowed reports whether robots.txt lets us fetch rawurl.
+func (d *Downloader) allowed(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return d.robotsFor(u).allowed(path)
+}
+
+// waitHost blocks until a request to u's host may start: fewer than
+// HostParallel requests to it are running and HostDelay, or the host's
+// Crawl-delay if longer, has passed since the last one started. The
+// returned function releases the slot.
+func (d *Downloader) waitHost(u *url.URL) func() {
+	h := d.host(u)
+	delay := max(d.HostDelay, d.robotsFor(u).crawlDelay)
+	h.slots <- struct{}{}
+	h.pacer.wait(delay)
+	return func() { <-h.slots }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := `# comment
+Sitemap: https://example.com/sitemap.xml
+User-agent: *
+Disallow: /private
+Allow: /private/open
+Crawl-delay: 2
+
+User-agent: wgetmirror
+User-agent: otherbot
+Disallow: /
+Allow: /docs/
+Disallow: /docs/*.pdf$
+Crawl-delay: 0.5
+`
+	cases := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		{"SomeBot/2.0", "/index.html", true},
+		{"SomeBot/2.0", "/private/x", false},
+		{"SomeBot/2.0", "/private/open/x", true},
+		{"wgetmirror/1.0", "/index.html", false},
+		{"wgetmirror/1.0", "/docs/a.html", true},
+		{"wgetmirror/1.0", "/docs/a.pdf", false},
+		{"wgetmirror/1.0", "/docs/a.pdf?x=1", true},
+		{"OtherBot", "/docs/", true},
+	}
+	for _, c := range cases {
+		got := parseRobots(strings.NewReader(robots), c.agent).allowed(c.path)
+		if got != c.want {
+			t.Errorf("%s: allowed(%q) = %v; want %v", c.agent, c.path, got, c.want)
+		}
+	}
+
+	if d := parseRobots(strings.NewReader(robots), "wgetmirror/1.0").crawlDelay; d != 500*time.Millisecond {
+		t.Errorf("crawl delay %v; want 500ms", d)
+	}
+	if s := parseRobots(strings.NewReader(robots), "SomeBot").sitemaps; len(s) != 1 || s[0] != "https://example.com/sitemap.xml" {
+		t.Errorf("sitemaps %q; want the one listed", s)
+	}
+	if !parseRobots(strings.NewReader(""), "wgetmirror").allowed("/x") {
+		t.Errorf("empty robots.txt disallows /x")
+	}
+}
+
+func TestRobotsAndPoliteness(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var starts []time.Time
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\nCrawl-delay: 0.05\n")
+			return
+		}
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		starts = append(starts, time.Now())
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/c">c</a><a href="/private/x">x</a>`)
+		}
+	}))
+	defer srv.Close()
+
+	d, _ := NewDownloader(srv.URL, t.TempDir(), 1, 4)
+	d.Start()
+	if len(paths) != 4 || len(d.Errors) != 0 {
+		t.Fatalf("fetched %v, errors %v", paths, d.Errors)
+	}
+	for _, p := range paths {
+		if strings.HasPrefix(p, "/private") {
+			t.Errorf("fetched disallowed %s", p)
+		}
+	}
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 45*time.Millisecond {
+			t.Errorf("requests %d and %d only %v apart", i-1, i, gap)
+		}
+	}
+
+	paths, starts, maxInFlight = nil, nil, 0
+	d, _ = NewDownloader(srv.URL, t.TempDir(), 1, 4)
+	d.IgnoreRobots = true
+	d.HostParallel = 1
+	d.Start()
+	if len(paths) != 5 || maxInFlight != 1 {
+		t.Errorf("with IgnoreRobots fetched %v, %d at once", paths, maxInFlight)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxSitemapSize is the most that is read of one sitemap, after
+// decompression; the sitemaps protocol allows 50MB.
+const maxSitemapSize = 50 << 20
+
+// maxSitemapNesting bounds how deep sitemap indexes may refer to others.
+const maxSitemapNesting = 3
+
+// sitemap is either a urlset, listing pages, or a sitemapindex, listing
+// other sitemaps.
+type sitemap struct {
+	XMLName  xml.Name
+	URLs     []sitemapURL `xml:"url"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURL struct {
+	Loc      string `xml:"loc"`
+	Priority string `xml:"priority"`
+}
+
+// seedSitemaps queues the pages listed in the sitemaps robots.txt names
+// for BaseURL's host, or in /sitemap.xml if it names none.
+func (d *Downloader) seedSitemaps() {
+	sitemaps := d.robotsFor(d.BaseURL).sitemaps
+	required := len(sitemaps) > 0
+	if !required {
+		sitemaps = []string{(&url.URL{Scheme: d.BaseURL.Scheme, Host: d.BaseURL.Host, Path: "/sitemap.xml"}).String()}
+	}
+	seen := make(map[string]bool)
+	for _, s := range sitemaps {
+		d.readSitemap(s, 0, required, seen)
+	}
+}
+
+// readSitemap fetches the sitemap at rawurl and queues its pages, following
+// sitemap indexes. A missing sitemap is only an error if required.
+func (d *Downloader) readSitemap(rawurl string, nesting int, required bool, seen map[string]bool) {
+	if seen[rawurl] || nesting > maxSitemapNesting {
+		return
+	}
+	seen[rawurl] = true
+
+	sm, err := d.fetchSitemap(rawurl, required)
+	if err != nil {
+		d.appendError(fmt.Errorf("error reading sitemap %s: %v", rawurl, err))
+		return
+	}
+	if sm == nil {
+		return
+	}
+	for _, entry := range sm.Sitemaps {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			d.readSitemap(loc, nesting+1, true, seen)
+		}
+	}
+	for _, entry := range sm.URLs {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" || !d.wanted(loc, false) {
+			continue
+		}
+		d.enqueue(loc, 0, sitemapPriority(entry.Priority), false)
+	}
+}
+
+// fetchSitemap downloads and parses a sitemap, gzipped or not. It returns
+// nil without an error for a missing sitemap that is not required.
+func (d *Downloader) fetchSitemap(rawurl string, required bool) (*sitemap, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !d.allowed(rawurl) {
+		return nil, nil
+	}
+	release := d.waitHost(u)
+	defer release()
+	req, err := d.newRequest(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if !required && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	var r io.Reader = bufio.NewReader(resp.Body)
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var sm sitemap
+	if err := xml.NewDecoder(io.LimitReader(r, maxSitemapSize)).Decode(&sm); err != nil {
+		return nil, err
+	}
+	if name := sm.XMLName.Local; name != "urlset" && name != "sitemapindex" {
+		return nil, fmt.Errorf("unexpected root element <%s>", name)
+	}
+	return &sm, nil
+}
+
+// sitemapPriority parses a <priority>, which defaults to 0.5 and lies
+// between 0 and 1.
+func sitemapPriority(s string) float64 {
+	p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return defaultPriority
+	}
+	return min(max(p, 0), 1)
+}
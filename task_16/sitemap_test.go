@@ -0,0 +1,138 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestFrontierOrder(t *testing.T) {
+	f := newFrontier()
+	for _, item := range []frontierItem{
+		{url: "deep", depth: 2, priority: defaultPriority},
+		{url: "first", depth: 1, priority: defaultPriority},
+		{url: "second", depth: 1, priority: defaultPriority},
+		{url: "important", depth: 3, priority: 0.9},
+		{url: "unimportant", depth: 0, priority: 0.1},
+	} {
+		f.push(item)
+	}
+	var got []string
+	for range 5 {
+		item, ok := f.pop()
+		if !ok {
+			t.Fatalf("frontier empty after %q", got)
+		}
+		got = append(got, item.url)
+		f.finish()
+	}
+	want := []string{"important", "first", "second", "deep", "unimportant"}
+	if !slices.Equal(got, want) {
+		t.Errorf("popped %q; want %q", got, want)
+	}
+	if _, ok := f.pop(); ok {
+		t.Errorf("pop on an exhausted frontier succeeded")
+	}
+}
+
+func TestSitemapPriority(t *testing.T) {
+	cases := map[string]float64{"": 0.5, "0.8": 0.8, " 1.0 ": 1, "2": 1, "-1": 0, "high": 0.5}
+	for in, want := range cases {
+		if got := sitemapPriority(in); got != want {
+			t.Errorf("sitemapPriority(%q) = %v; want %v", in, got, want)
+		}
+	}
+}
+
+// orderServer serves pages linking to the given paths and records the
+// order they are fetched in.
+type orderServer struct {
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (s *orderServer) record(path string) {
+	s.mu.Lock()
+	s.fetched = append(s.fetched, path)
+	s.mu.Unlock()
+}
+
+func TestMirrorSitemaps(t *testing.T) {
+	var s orderServer
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record(r.URL.Path)
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "Sitemap: %s/index.xml.gz\n", srv.URL)
+		case "/index.xml.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			zw := gzip.NewWriter(w)
+			fmt.Fprintf(zw, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%s/pages.xml</loc></sitemap>
+</sitemapindex>`, srv.URL)
+			zw.Close()
+		case "/pages.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/low.html</loc><priority>0.2</priority></url>
+  <url><loc>%[1]s/high.html</loc><priority>0.9</priority></url>
+  <url><loc>https://elsewhere.example/x.html</loc></url>
+</urlset>`, srv.URL)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "page")
+		}
+	}))
+	defer srv.Close()
+
+	d, _ := NewDownloader(srv.URL, t.TempDir(), 1, 1)
+	d.UseSitemaps = true
+	d.Start()
+	want := []string{"/robots.txt", "/index.xml.gz", "/pages.xml", "/high.html", "/", "/low.html"}
+	if len(d.Errors) != 0 || !slices.Equal(s.fetched, want) {
+		t.Errorf("fetched %q, errors %v; want %q", s.fetched, d.Errors, want)
+	}
+}
+
+func TestMirrorBreadthFirstMaxPages(t *testing.T) {
+	links := map[string][]string{
+		"/":   {"/a", "/b"},
+		"/a":  {"/a1", "/a2"},
+		"/b":  {"/b1"},
+		"/a1": {"/a11"},
+	}
+	var s orderServer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record(r.URL.Path)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<img src="/logo.png">`)
+		for _, l := range links[r.URL.Path] {
+			fmt.Fprintf(w, `<a href="%s">%s</a>`, l, l)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		maxPages int
+		want     []string
+	}{
+		{0, []string{"/", "/logo.png", "/a", "/b", "/a1", "/a2", "/b1", "/a11"}},
+		{4, []string{"/", "/logo.png", "/a", "/b", "/a1"}},
+	}
+	for _, c := range cases {
+		s.fetched = nil
+		d, _ := NewDownloader(srv.URL, t.TempDir(), 5, 1)
+		d.IgnoreRobots = true
+		d.MaxPages = c.maxPages
+		d.Start()
+		if len(d.Errors) != 0 || !slices.Equal(s.fetched, c.want) {
+			t.Errorf("max pages %d: fetched %q, errors %v; want %q", c.maxPages, s.fetched, d.Errors, c.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFile is kept under RootDir so that a re-run can revalidate what it
+// already has, resume partial files and pick up the unfinished frontier.
+const stateFile = ".wgetmirror-state.json"
+
+// urlState is what we know about a URL from earlier runs.
+type urlState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"`
+	ContentType  string `json:"content_type,omitempty"`
+	Complete     bool   `json:"complete"`
+	// Links and Requisites are the links found in an HTML page or a
+	// stylesheet, so one that has not changed can be crawled without
+	// downloading it again.
+	Links      []string `json:"links,omitempty"`
+	Requisites []string `json:"requisites,omitempty"`
+}
+
+func (s *urlState) setLinks(links []crawlLink) {
+	s.Links, s.Requisites = nil, nil
+	for _, l := range links {
+		if l.requisite {
+			s.Requisites = append(s.Requisites, l.url)
+		} else {
+			s.Links = append(s.Links, l.url)
+		}
+	}
+}
+
+func (s *urlState) crawlLinks() []crawlLink {
+	var links []crawlLink
+	for _, u := range s.Links {
+		links = append(links, crawlLink{url: u})
+	}
+	for _, u := range s.Requisites {
+		links = append(links, crawlLink{url: u, requisite: true})
+	}
+	return links
+}
+
+func (s *urlState) validator() string {
+	if s.ETag != "" {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+type mirrorState struct {
+	mu       sync.Mutex
+	URLs     map[string]*urlState `json:"urls"`
+	Frontier map[string]int       `json:"frontier"`
+
+	saveMu   sync.Mutex
+	lastSave time.Time
+}
+
+func newMirrorState() *mirrorState {
+	return &mirrorState{URLs: make(map[string]*urlState), Frontier: make(map[string]int)}
+}
+
+func (s *mirrorState) get(rawurl string) urlState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.URLs[rawurl]; ok {
+		return *e
+	}
+	return urlState{}
+}
+
+func (s *mirrorState) set(rawurl string, e urlState) {
+	s.mu.Lock()
+	s.URLs[rawurl] = &e
+	s.mu.Unlock()
+}
+
+func (s *mirrorState) queue(rawurl string, depth int) {
+	s.mu.Lock()
+	s.Frontier[rawurl] = depth
+	s.mu.Unlock()
+}
+
+func (s *mirrorState) done(rawurl string) {
+	s.mu.Lock()
+	delete(s.Frontier, rawurl)
+	s.mu.Unlock()
+}
+
+func (d *Downloader) statePath() string {
+	return filepath.Join(d.RootDir, stateFile)
+}
+
+// loadState reads the state left by an earlier run, if any.
+func (d *Downloader) loadState() error {
+	data, err := os.ReadFile(d.statePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s := newMirrorState()
+	if err := json.Unmarshal(data, s); err != nil {
+		return err
+	}
+	d.state = s
+	return nil
+}
+
+// saveState writes the state file, replacing the old one only once the
+// new one is complete.
+func (d *Downloader) saveState() error {
+	s := d.state
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	data, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.RootDir, 0755); err != nil {
+		return err
+	}
+	tmp := d.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	s.lastSave = time.Now()
+	return os.Rename(tmp, d.statePath())
+}
+
+// checkpoint saves the state if it has not been saved for a second, so an
+// interrupted run loses little.
+func (d *Downloader) checkpoint() {
+	d.state.saveMu.Lock()
+	due := time.Since(d.state.lastSave) >= time.Second
+	d.state.saveMu.Unlock()
+	if due {
+		if err := d.saveState(); err != nil {
+			d.appendError(fmt.Errorf("error saving state: %v", err))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResumeAndRevalidate(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 1000)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	var log []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, log: func(status int) {
+			mu.Lock()
+			log = append(log, fmt.Sprintf("%s %d %s", r.URL.Path, status, r.Header.Get("Range")))
+			mu.Unlock()
+		}}
+		switch r.URL.Path {
+		case "/":
+			rec.Header().Set("Content-Type", "text/html")
+			rec.Header().Set("ETag", `"index-1"`)
+			http.ServeContent(rec, r, "index.html", modTime, strings.NewReader(`<a href="/big.bin">big</a>`))
+		case "/big.bin":
+			rec.Header().Set("ETag", `"big-1"`)
+			http.ServeContent(rec, r, "big.bin", modTime, bytes.NewReader(big))
+		default:
+			rec.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(rec, "orphan")
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	bigPath := filepath.Join(root, strings.Split(host, ":")[0], "big.bin")
+	run := func(want ...string) {
+		t.Helper()
+		log = nil
+		d, _ := NewDownloader(srv.URL, root, 2, 2)
+		d.IgnoreRobots = true
+		d.Start()
+		slices.Sort(log)
+		if len(d.Errors) != 0 || !slices.Equal(log, want) {
+			t.Errorf("requests %q, errors %v; want %q", log, d.Errors, want)
+		}
+		if data, err := os.ReadFile(bigPath); err != nil || !bytes.Equal(data, big) {
+			t.Errorf("big.bin: %d bytes, %v", len(data), err)
+		}
+	}
+
+	run("/ 200 ", "/big.bin 200 ")
+	run("/ 304 ", "/big.bin 304 ")
+
+	statePath := filepath.Join(root, stateFile)
+	s := newMirrorState()
+	data, _ := os.ReadFile(statePath)
+	if err := json.Unmarshal(data, s); err != nil {
+		t.Fatal(err)
+	}
+	s.URLs[srv.URL+"/big.bin"].Complete = false
+	s.Frontier[srv.URL+"/orphan"] = 1
+	data, _ = json.Marshal(s)
+	os.WriteFile(statePath, data, 0644)
+	os.Remove(bigPath)
+	os.WriteFile(bigPath+".part", big[:4000], 0644)
+
+	run("/ 304 ", "/big.bin 206 bytes=4000-", "/orphan 200 ")
+	if _, err := os.Stat(bigPath + ".part"); err == nil {
+		t.Errorf("big.bin.part left behind")
+	}
+}
+
+// statusRecorder logs the status of a response before it is sent.
+type statusRecorder struct {
+	http.ResponseWriter
+	log         func(status int)
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.log(status)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WARCWriter is an Output that archives each response with its request as
+// WARC 1.1 records in gzipped files named Prefix-TIMESTAMP-NNNNN.warc.gz.
+// Each record is a gzip member of its own, and a new file with its own
+// warcinfo record is started once the current one reaches MaxSize bytes.
+type WARCWriter struct {
+	Prefix  string
+	MaxSize int64
+	// Software and Description go in the warcinfo records.
+	Software    string
+	Description string
+	// Redact names request headers, besides Authorization and Cookie,
+	// whose values are left out of the archive.
+	Redact []string
+
+	mu       sync.Mutex
+	f        *os.File
+	written  int64
+	seq      int
+	started  string
+	infoID   string
+	filename string
+}
+
+// NewWARCWriter returns a WARCWriter writing files starting with prefix,
+// which may include a directory.
+func NewWARCWriter(prefix string, maxSize int64) *WARCWriter {
+	return &WARCWriter{Prefix: prefix, MaxSize: maxSize, Software: "wgetmirror/1.0"}
+}
+
+// warcFile buffers a response body in a temporary file until it is
+// complete, since a record's length and digests come before its block.
+type warcFile struct {
+	w       *WARCWriter
+	resp    *http.Response
+	tmp     *os.File
+	buf     *bufio.Writer
+	digest  hash.Hash
+	size    int64
+	started time.Time
+}
+
+func (w *WARCWriter) Create(resp *http.Response, localPath string, offset int64) (OutputFile, error) {
+	tmp, err := os.CreateTemp("", "wgetmirror-warc-*")
+	if err != nil {
+		return nil, err
+	}
+	return &warcFile{w: w, resp: resp, tmp: tmp, buf: bufio.NewWriter(tmp), digest: sha1.New(), started: time.Now()}, nil
+}
+
+func (f *warcFile) Raw() io.Writer     { return f }
+func (f *warcFile) Content() io.Writer { return io.Discard }
+
+func (f *warcFile) Write(p []byte) (int, error) {
+	f.digest.Write(p)
+	f.size += int64(len(p))
+	return f.buf.Write(p)
+}
+
+func (f *warcFile) Commit() error {
+	defer f.Abort()
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	return f.w.writeExchange(f.resp, f.started, io.NewSectionReader(f.tmp, 0, f.size), f.digest.Sum(nil))
+}
+
+func (f *warcFile) Abort() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+// writeExchange writes the request and response records of one fetch.
+func (w *WARCWriter) writeExchange(resp *http.Response, date time.Time, payload *io.SectionReader, payloadDigest []byte) error {
+	target := resp.Request.URL.String()
+	respID, reqID := newRecordID(), newRecordID()
+
+	var reqBlock bytes.Buffer
+	fmt.Fprintf(&reqBlock, "%s %s HTTP/1.1\r\n", resp.Request.Method, resp.Request.URL.RequestURI())
+	fmt.Fprintf(&reqBlock, "Host: %s\r\n", resp.Request.URL.Host)
+	writeHeader(&reqBlock, w.redact(resp.Request.Header))
+
+	var respHead bytes.Buffer
+	fmt.Fprintf(&respHead, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	writeHeader(&respHead, resp.Header)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.rollover(); err != nil {
+		return err
+	}
+
+	blockDigest := sha1.New()
+	blockDigest.Write(respHead.Bytes())
+	if _, err := io.Copy(blockDigest, payload); err != nil {
+		return err
+	}
+	payload.Seek(0, io.SeekStart)
+	err := w.writeRecord([][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", respID},
+		{"WARC-Date", date.UTC().Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", target},
+		{"WARC-Warcinfo-ID", w.infoID},
+		{"WARC-Concurrent-To", reqID},
+		{"WARC-Block-Digest", sha1Digest(blockDigest.Sum(nil))},
+		{"WARC-Payload-Digest", sha1Digest(payloadDigest)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, io.MultiReader(bytes.NewReader(respHead.Bytes()), payload), int64(respHead.Len())+payload.Size())
+	if err != nil {
+		return err
+	}
+	return w.writeRecord([][2]string{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", reqID},
+		{"WARC-Date", date.UTC().Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", target},
+		{"WARC-Warcinfo-ID", w.infoID},
+		{"WARC-Concurrent-To", respID},
+		{"WARC-Block-Digest", sha1Digest(sha1Sum(reqBlock.Bytes()))},
+		{"Content-Type", "application/http;msgtype=request"},
+	}, bytes.NewReader(reqBlock.Bytes()), int64(reqBlock.Len()))
+}
+
+// rollover opens the first file, or the next one once the current one
+// has reached MaxSize, and starts it with a warcinfo record.
+func (w *WARCWriter) rollover() error {
+	if w.f != nil && (w.MaxSize <= 0 || w.written < w.MaxSize) {
+		return nil
+	}
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+	if w.started == "" {
+		w.started = time.Now().UTC().Format("20060102150405")
+	}
+	w.filename = fmt.Sprintf("%s-%s-%05d.warc.gz", w.Prefix, w.started, w.seq)
+	w.seq++
+	if err := os.MkdirAll(filepath.Dir(w.filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(w.filename)
+	if err != nil {
+		return err
+	}
+	w.f, w.written = f, 0
+
+	var info bytes.Buffer
+	fmt.Fprintf(&info, "software: %s\r\nformat: WARC File Format 1.1\r\nconformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n", w.Software)
+	if w.Description != "" {
+		fmt.Fprintf(&info, "description: %s\r\n", w.Description)
+	}
+	w.infoID = newRecordID()
+	return w.writeRecord([][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.infoID},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"WARC-Filename", filepath.Base(w.filename)},
+		{"Content-Type", "application/warc-fields"},
+	}, bytes.NewReader(info.Bytes()), int64(info.Len()))
+}
+
+// writeRecord writes one record as a gzip member of its own.
+func (w *WARCWriter) writeRecord(headers [][2]string, block io.Reader, length int64) error {
+	cw := &countingWriter{w: w.f}
+	zw := gzip.NewWriter(cw)
+	bw := bufio.NewWriter(zw)
+	bw.WriteString("WARC/1.1\r\n")
+	for _, h := range headers {
+		fmt.Fprintf(bw, "%s: %s\r\n", h[0], h[1])
+	}
+	fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", length)
+	if _, err := io.Copy(bw, block); err != nil {
+		return err
+	}
+	bw.WriteString("\r\n\r\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	w.written += cw.n
+	return nil
+}
+
+// Close closes the current file.
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+func (w *WARCWriter) closeFile() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// redact returns a copy of the request header h with the values of
+// credentials replaced, since WARC files are made to be shared.
+func (w *WARCWriter) redact(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range append([]string{"Authorization", "Cookie"}, w.Redact...) {
+		name = http.CanonicalHeaderKey(name)
+		if len(h[name]) > 0 {
+			h[name] = []string{"[redacted]"}
+		}
+	}
+	return h
+}
+
+func writeHeader(b *bytes.Buffer, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(b, "%s: %s\r\n", k, strings.ReplaceAll(v, "\n", " "))
+		}
+	}
+	b.WriteString("\r\n")
+}
+
+func sha1Sum(p []byte) []byte {
+	sum := sha1.Sum(p)
+	return sum[:]
+}
+
+func sha1Digest(sum []byte) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum)
+}
+
+func newRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
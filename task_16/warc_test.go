@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type warcRecord struct {
+	headers map[string]string
+	block   []byte
+}
+
+// readWARC reads a WARC file, checking that each record is a gzip member
+// of its own.
+func readWARC(t *testing.T, path string) []warcRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var records []warcRecord
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return records
+		}
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr.Multistream(false)
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, rest, ok := bytes.Cut(data, []byte("\r\n\r\n"))
+		lines := strings.Split(string(head), "\r\n")
+		if !ok || lines[0] != "WARC/1.1" {
+			t.Fatalf("bad record %q", data)
+		}
+		rec := warcRecord{headers: map[string]string{}}
+		for _, line := range lines[1:] {
+			k, v, _ := strings.Cut(line, ": ")
+			rec.headers[k] = v
+		}
+		n, _ := strconv.Atoi(rec.headers["Content-Length"])
+		if len(rest) != n+4 || !bytes.HasSuffix(rest, []byte("\r\n\r\n")) {
+			t.Fatalf("record of %d bytes for Content-Length %d", len(rest), n)
+		}
+		rec.block = rest[:n]
+		records = append(records, rec)
+	}
+}
+
+func TestWARCOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/a.txt">a</a>`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "plain text")
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	warc := NewWARCWriter(filepath.Join(root, "warc", "crawl"), 1)
+	d, _ := NewDownloader(srv.URL, root, 1, 4)
+	d.IgnoreRobots = true
+	d.Output = MultiOutput{dirOutput{}, warc}
+	d.Start()
+	if err := warc.Close(); err != nil || len(d.Errors) != 0 {
+		t.Fatalf("close %v, errors %v", err, d.Errors)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "127.0.0.1", "a.txt")); err != nil || string(data) != "plain text" {
+		t.Errorf("local tree a.txt: %q, %v", data, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(root, "warc", "crawl-*.warc.gz"))
+	if len(files) != 2 {
+		t.Fatalf("WARC files %q; want one per exchange", files)
+	}
+	responses := map[string]string{}
+	for i, file := range files {
+		if !strings.HasSuffix(file, fmt.Sprintf("-%05d.warc.gz", i)) {
+			t.Errorf("file %d named %s", i, file)
+		}
+		records := readWARC(t, file)
+		if len(records) != 3 || records[0].headers["WARC-Type"] != "warcinfo" ||
+			records[1].headers["WARC-Type"] != "response" || records[2].headers["WARC-Type"] != "request" {
+			t.Fatalf("%s: records %v", file, records)
+		}
+		if records[0].headers["WARC-Filename"] != filepath.Base(file) || !bytes.Contains(records[0].block, []byte("format: WARC File Format 1.1")) {
+			t.Errorf("warcinfo %v %q", records[0].headers, records[0].block)
+		}
+		resp, req := records[1], records[2]
+		if resp.headers["WARC-Concurrent-To"] != req.headers["WARC-Record-ID"] || resp.headers["WARC-Warcinfo-ID"] != records[0].headers["WARC-Record-ID"] {
+			t.Errorf("records not linked: %v %v", resp.headers, req.headers)
+		}
+		for _, rec := range []warcRecord{resp, req} {
+			if want := sha1Digest(sha1Sum(rec.block)); rec.headers["WARC-Block-Digest"] != want {
+				t.Errorf("block digest %s; want %s", rec.headers["WARC-Block-Digest"], want)
+			}
+		}
+		_, payload, _ := bytes.Cut(resp.block, []byte("\r\n\r\n"))
+		if want := sha1Digest(sha1Sum(payload)); resp.headers["WARC-Payload-Digest"] != want {
+			t.Errorf("payload digest %s; want %s", resp.headers["WARC-Payload-Digest"], want)
+		}
+		if !bytes.HasPrefix(resp.block, []byte("HTTP/1.1 200 OK\r\n")) || !bytes.HasPrefix(req.block, []byte("GET /")) ||
+			!bytes.Contains(req.block, []byte("User-Agent: wgetmirror/1.0\r\n")) {
+			t.Errorf("blocks %q %q", resp.block, req.block)
+		}
+		responses[resp.headers["WARC-Target-URI"]] = string(payload)
+	}
+	if responses[srv.URL+"/"] != `<a href="/a.txt">a</a>` || responses[srv.URL+"/a.txt"] != "plain text" {
+		t.Errorf("payloads %q", responses)
+	}
+}
+
+func TestWARCRedactsCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "private")
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	warc := NewWARCWriter(filepath.Join(root, "crawl"), 1<<30)
+	warc.Redact = []string{"x-api-key"}
+	d, _ := NewDownloader(srv.URL+"/doc.txt", root, 0, 1)
+	d.IgnoreRobots = true
+	d.BearerToken = "SECRETTOKEN"
+	d.Headers = http.Header{"X-Api-Key": {"SECRETKEY"}}
+	jar, _ := cookiejar.New(nil)
+	u, _ := url.Parse(srv.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "SECRETSESSION"}})
+	d.Client.Jar = jar
+	d.Output = MultiOutput{dirOutput{}, warc}
+	d.Start()
+	if err := warc.Close(); err != nil || len(d.Errors) != 0 {
+		t.Fatalf("close %v, errors %v", err, d.Errors)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(root, "crawl-*.warc.gz"))
+	if len(files) != 1 {
+		t.Fatalf("WARC files %q", files)
+	}
+	records := readWARC(t, files[0])
+	req := records[len(records)-1].block
+	for _, want := range []string{"Authorization: [redacted]\r\n", "Cookie: [redacted]\r\n", "X-Api-Key: [redacted]\r\n"} {
+		if !bytes.Contains(req, []byte(want)) {
+			t.Errorf("request record lacks %q:\n%s", want, req)
+		}
+	}
+	for _, rec := range records {
+		if bytes.Contains(rec.block, []byte("SECRET")) {
+			t.Errorf("%s record holds a credential:\n%s", rec.headers["WARC-Type"], rec.block)
+		}
+	}
+}